@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// admissionEWMAAlpha weights how quickly the handshake-rate estimate
+// reacts to new attempts; ~0.3 tracks a 10s-ish window without being
+// noisy on individual arrivals.
+const admissionEWMAAlpha = 0.3
+
+// admissionClientPressureThreshold is the fraction of MaxClients at
+// which difficulty starts climbing due to occupancy alone.
+const admissionClientPressureThreshold = 0.8
+
+// admissionFailureLRUSize bounds the per-/24 recent-failure tracker.
+const admissionFailureLRUSize = 1024
+
+// admissionFailureWindow is how long a recorded failure keeps counting
+// against a /24 before it ages out.
+const admissionFailureWindow = 5 * time.Minute
+
+// AdmissionController computes a dynamic proof-of-work difficulty for
+// incoming handshakes from three pressure signals: an EWMA of the
+// handshake attempt rate, how close the relay is to MaxClients, and a
+// per-source-/24 count of recent invalid solutions.
+type AdmissionController struct {
+	cfg *Config
+
+	mu       sync.Mutex
+	rate     float64 // EWMA of handshake attempts/sec
+	lastTick time.Time
+
+	failures *failureLRU
+}
+
+func NewAdmissionController(cfg *Config) *AdmissionController {
+	return &AdmissionController{
+		cfg:      cfg,
+		lastTick: time.Now(),
+		failures: newFailureLRU(admissionFailureLRUSize),
+	}
+}
+
+// RecordAttempt registers one incoming handshake attempt, updating the
+// EWMA of the attempt rate.
+func (a *AdmissionController) RecordAttempt() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(a.lastTick).Seconds()
+	if elapsed <= 0 {
+		elapsed = 0.001
+	}
+	instantRate := 1 / elapsed
+	a.rate = admissionEWMAAlpha*instantRate + (1-admissionEWMAAlpha)*a.rate
+	a.lastTick = now
+}
+
+// RecordFailure registers an invalid PoW solution from addr, raising the
+// required difficulty for that /24 for a while.
+func (a *AdmissionController) RecordFailure(addr net.Addr) {
+	if subnet := subnet24(addr); subnet != "" {
+		a.failures.touch(subnet)
+	}
+}
+
+// Difficulty computes the PoW difficulty (leading zero bits) a new
+// handshake from addr must solve, given the relay's current client
+// count.
+func (a *AdmissionController) Difficulty(addr net.Addr, clientCount int) uint8 {
+	bits := float64(a.cfg.PoWDifficulty) + a.rateBonus() + a.clientPressureBonus(clientCount)
+	if subnet := subnet24(addr); subnet != "" {
+		if n := a.failures.count(subnet); n > 0 {
+			bits += math.Log2(float64(n) + 1)
+		}
+	}
+	return a.clamp(bits)
+}
+
+// CurrentBaseline reports the difficulty a fresh, never-failed source
+// would currently see — used for the /debug/vars metric, since
+// per-source failure bonuses are inherently address-specific.
+func (a *AdmissionController) CurrentBaseline(clientCount int) uint8 {
+	bits := float64(a.cfg.PoWDifficulty) + a.rateBonus() + a.clientPressureBonus(clientCount)
+	return a.clamp(bits)
+}
+
+func (a *AdmissionController) rateBonus() float64 {
+	if a.cfg.PoWSoftThreshold <= 0 {
+		return 0
+	}
+	a.mu.Lock()
+	rate := a.rate
+	a.mu.Unlock()
+	if rate <= a.cfg.PoWSoftThreshold {
+		return 0
+	}
+	return math.Log2(rate / a.cfg.PoWSoftThreshold)
+}
+
+func (a *AdmissionController) clientPressureBonus(clientCount int) float64 {
+	if a.cfg.MaxClients <= 0 {
+		return 0
+	}
+	load := float64(clientCount) / float64(a.cfg.MaxClients)
+	if load <= admissionClientPressureThreshold {
+		return 0
+	}
+	// Scales up to roughly +4 bits as load approaches 100%.
+	return (load - admissionClientPressureThreshold) * 20
+}
+
+func (a *AdmissionController) clamp(bits float64) uint8 {
+	base := float64(a.cfg.PoWDifficulty)
+	max := float64(a.cfg.PoWMaxDifficulty)
+	if bits < base {
+		bits = base
+	}
+	if max > 0 && bits > max {
+		bits = max
+	}
+	return uint8(bits)
+}
+
+// subnet24 extracts the /24 (IPv4) or full address (IPv6, which has no
+// equivalent default aggregation here) from a net.Addr for failure
+// tracking.
+func subnet24(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.0/24", ip4[0], ip4[1], ip4[2])
+	}
+	return ip.String()
+}
+
+// failureLRU is a fixed-capacity, least-recently-used map from subnet
+// key to a recent invalid-solution count.
+type failureLRU struct {
+	mu    sync.Mutex
+	cap   int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type failureEntry struct {
+	key   string
+	count int
+	last  time.Time
+}
+
+func newFailureLRU(capacity int) *failureLRU {
+	return &failureLRU{
+		cap:   capacity,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (l *failureLRU) touch(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		entry := el.Value.(*failureEntry)
+		if time.Since(entry.last) > admissionFailureWindow {
+			entry.count = 1 // previous streak aged out; this failure starts a fresh one
+		} else {
+			entry.count++
+		}
+		entry.last = time.Now()
+		l.order.MoveToFront(el)
+		return
+	}
+
+	entry := &failureEntry{key: key, count: 1, last: time.Now()}
+	el := l.order.PushFront(entry)
+	l.items[key] = el
+
+	if l.order.Len() > l.cap {
+		oldest := l.order.Back()
+		if oldest != nil {
+			l.order.Remove(oldest)
+			delete(l.items, oldest.Value.(*failureEntry).key)
+		}
+	}
+}
+
+func (l *failureLRU) count(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return 0
+	}
+	entry := el.Value.(*failureEntry)
+	if time.Since(entry.last) > admissionFailureWindow {
+		return 0
+	}
+	return entry.count
+}
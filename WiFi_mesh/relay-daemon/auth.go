@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,33 +22,94 @@ import (
 //
 // Trust model:
 //   - Each relay has its own Ed25519 keypair (generated on first run)
-//   - A CA signs each relay's public key, producing a 64-byte certificate
-//   - On mesh recv, the packet's signer pubkey is verified against the CA
-//   - Revoked keys are checked against a periodically reloaded CRL file
+//   - A CA issues each relay a short-lived certificate —
+//     [serial:8][notBefore:8][notAfter:8][ca-sig:64] — binding a
+//     validity window and a unique serial to the relay's public key
+//   - On mesh recv, the packet's signer pubkey and certificate are
+//     verified against the CA, the current time, and the CRL (by
+//     serial, not pubkey, so a key rotation doesn't carry forward an
+//     old revocation)
+//   - RenewLoop fetches a fresh certificate before the current one
+//     expires; a revoked relay simply stops being renewed and its
+//     certificate expires on schedule, which is what lets CertRenewURL
+//     substitute for CRL distribution
+//   - The CRL file is reloaded periodically: a CA-signed list of
+//     revoked serials, or, with cfg.CRLLegacy, a plain list of revoked
+//     pubkeys for open (non-CA) deployments
 type RelayAuth struct {
-	PrivateKey  ed25519.PrivateKey
-	PublicKey   ed25519.PublicKey
-	Certificate []byte // 64-byte CA signature over this relay's public key
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
 
-	CAPubKey    ed25519.PublicKey // the CA public key (trusted root)
+	CAPubKey ed25519.PublicKey // the CA public key (trusted root)
 
-	mu          sync.RWMutex
-	revokedKeys map[string]bool // hex pubkeys that have been revoked
-	crlPath     string
-	crlModTime  time.Time
+	certMu       sync.RWMutex
+	Certificate  []byte // 88-byte short-lived CA certificate; see format above
+	certNotAfter int64  // unix seconds from Certificate's notAfter; 0 if no cert loaded
+	certPath     string
+	certModTime  time.Time
 
-	// Peer certificate cache: hex(pubkey) → already verified against CA
-	certCache   map[string]bool
-	certCacheMu sync.RWMutex
+	mu            sync.RWMutex
+	revokedKeys   map[string]bool // hex big-endian cert serials (signed CRL) or hex pubkeys (legacy CRL) that have been revoked
+	crlPath       string
+	crlModTime    time.Time
+	crlThisUpdate int64 // thisUpdate of the currently loaded signed CRL, for rollback rejection
+	crlLegacy     bool  // true: plain newline-delimited hex list, no signature (open/non-CA deployments)
+
+	// Noise-IK session layer (mesh_session.go): xPriv is our static
+	// Curve25519 key, derived from PrivateKey so the CA's signature over
+	// our Ed25519 key continues to anchor trust for the handshake.
+	xPriv *ecdh.PrivateKey
+
+	peersMu sync.Mutex
+	peers   map[string]*peerSession // hex(ed25519 pubkey) → session state
+
+	indexMu    sync.Mutex
+	nextIndex  uint32
+	indexPeers map[uint32]string // local peer-index → hex(ed25519 pubkey)
+}
+
+type sessionState int
+
+const (
+	sessionNone sessionState = iota
+	sessionHandshaking
+	sessionEstablished
+)
+
+// peerSession tracks one peer relay's last known address, in-progress
+// handshake (if any), and established Noise session (if any).
+type peerSession struct {
+	mu          sync.Mutex
+	state       sessionState
+	addr        string // last known transport-level address (informational only)
+	hs          *noiseHandshake
+	localIndex  uint32
+	remoteIndex uint32
+	session     *meshSession
+
+	// certSerial is the serial of the most recently verified CA
+	// certificate this peer presented (CA mode only), kept current by
+	// RecordPeerCert on every signed envelope so an established session
+	// stops being trusted as soon as that serial is revoked, without
+	// waiting for the next rekey.
+	certSerial     uint64
+	haveCertSerial bool
 }
 
 // NewRelayAuth loads or generates an Ed25519 keypair, loads the CA
-// public key and this relay's certificate, and initializes the CRL.
-func NewRelayAuth(keyDir string, caKeyHex string, crlPath string) (*RelayAuth, error) {
+// public key and this relay's certificate, and initializes the CRL. If
+// certRenewURL is non-empty, it also starts a background loop that
+// renews the certificate from that endpoint before it expires;
+// certReloadInterval controls how often relay.cert is re-read from
+// disk to pick up a certificate reissued by other means (e.g. an
+// operator running `mesh-ca sign`).
+func NewRelayAuth(keyDir string, caKeyHex string, crlPath string, certRenewURL string, certReloadInterval time.Duration, crlLegacy bool) (*RelayAuth, error) {
 	auth := &RelayAuth{
 		revokedKeys: make(map[string]bool),
-		certCache:   make(map[string]bool),
 		crlPath:     crlPath,
+		crlLegacy:   crlLegacy,
+		peers:       make(map[string]*peerSession),
+		indexPeers:  make(map[uint32]string),
 	}
 
 	// Load CA public key
@@ -79,15 +144,26 @@ func NewRelayAuth(keyDir string, caKeyHex string, crlPath string) (*RelayAuth, e
 		log.Printf("Generated new relay signing key: %s…", hex.EncodeToString(pub)[:16])
 	}
 
-	// Load relay certificate (CA's signature over our public key)
+	if xPriv, err := newNoiseStaticKey(auth.PrivateKey); err != nil {
+		log.Printf("WARNING: Noise static key derivation failed, mesh sessions disabled: %v", err)
+	} else {
+		auth.xPriv = xPriv
+	}
+
+	// Load relay certificate (short-lived CA cert over our public key)
 	certPath := filepath.Join(keyDir, "relay.cert")
-	if certData, err := os.ReadFile(certPath); err == nil {
-		cert, err := hex.DecodeString(strings.TrimSpace(string(certData)))
-		if err == nil && len(cert) == ed25519.SignatureSize {
-			auth.Certificate = cert
-			log.Printf("Loaded relay certificate")
-		} else {
-			log.Printf("WARNING: invalid relay certificate file, will run without cert")
+	auth.certPath = certPath
+	if info, statErr := os.Stat(certPath); statErr == nil {
+		if certData, err := os.ReadFile(certPath); err == nil {
+			cert, err := hex.DecodeString(strings.TrimSpace(string(certData)))
+			if err == nil && len(cert) == certLen {
+				auth.Certificate = cert
+				auth.certNotAfter = parseCertNotAfter(cert)
+				auth.certModTime = info.ModTime()
+				log.Printf("Loaded relay certificate (valid until %s)", time.Unix(auth.certNotAfter, 0).UTC().Format(time.RFC3339))
+			} else {
+				log.Printf("WARNING: invalid relay certificate file, will run without cert")
+			}
 		}
 	}
 
@@ -97,6 +173,14 @@ func NewRelayAuth(keyDir string, caKeyHex string, crlPath string) (*RelayAuth, e
 	// Background CRL reload every 60 seconds
 	go auth.crlReloadLoop()
 
+	// Background certificate renewal, if a renewal service is configured
+	go auth.RenewLoop(certRenewURL, keyDir)
+
+	// Background relay.cert hot-reload, so a certificate reissued by an
+	// operator (e.g. `mesh-ca sign`) is picked up without a restart,
+	// independent of whether RenewLoop's CertRenewURL is configured.
+	go auth.certFileReloadLoop(certReloadInterval)
+
 	return auth, nil
 }
 
@@ -117,12 +201,27 @@ func (a *RelayAuth) IsSelf(pubKey []byte) bool {
 }
 
 // IsRevoked returns true if the given relay public key is on the CRL.
+// Only meaningful in legacy (non-CA) mode, where there's no per-cert
+// serial to revoke and the raw pubkey is the only identity available.
 func (a *RelayAuth) IsRevoked(pubKey []byte) bool {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 	return a.revokedKeys[hex.EncodeToString(pubKey)]
 }
 
+// IsSerialRevoked returns true if the given certificate serial is on
+// the signed CRL. CA-mode revocation is keyed on the serial rather than
+// the pubkey, so a relay that rotates its key after a compromise isn't
+// permanently banned by its old key's revocation — only the specific
+// compromised certificate is.
+func (a *RelayAuth) IsSerialRevoked(serial uint64) bool {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], serial)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.revokedKeys[hex.EncodeToString(buf[:])]
+}
+
 // IsTrustedLegacy checks trust in non-CA (open) mode. Only rejects
 // own key and revoked keys.
 func (a *RelayAuth) IsTrustedLegacy(pubKey []byte) bool {
@@ -132,34 +231,216 @@ func (a *RelayAuth) IsTrustedLegacy(pubKey []byte) bool {
 	return !a.IsRevoked(pubKey)
 }
 
-// VerifyCertificate checks if a relay's certificate (CA signature over
-// its public key) is valid, and caches the result if so.
+// VerifyCertificate checks a relay's short-lived certificate —
+// [serial:8][notBefore:8][notAfter:8][ca-sig:64] — against the CA
+// public key, the claimed pubkey, the current time, and the CRL.
+// Unlike the original indefinite-cache design, this is re-checked on
+// every envelope: a short-lived cert that isn't renewed must stop
+// verifying once it expires, which is the whole point of the renewal
+// model. Revocation is checked by serial rather than pubkey, so a relay
+// that rotates its key after a compromise doesn't carry the revocation
+// forward onto its new, legitimate key.
 func (a *RelayAuth) VerifyCertificate(pubKey, cert []byte) bool {
-	if a.CAPubKey == nil || len(cert) != ed25519.SignatureSize {
+	if a.CAPubKey == nil || len(cert) != certLen {
 		return false
 	}
-	if !ed25519.Verify(a.CAPubKey, pubKey, cert) {
+	signed := make([]byte, 0, serialLen+2*timestampLen+len(pubKey))
+	signed = append(signed, cert[:serialLen+2*timestampLen]...)
+	signed = append(signed, pubKey...)
+	sig := cert[serialLen+2*timestampLen:]
+	if !ed25519.Verify(a.CAPubKey, signed, sig) {
 		return false
 	}
-	pubHex := hex.EncodeToString(pubKey)
-	a.certCacheMu.Lock()
-	a.certCache[pubHex] = true
-	a.certCacheMu.Unlock()
-	return true
+	serial := binary.BigEndian.Uint64(cert[:serialLen])
+	if a.IsSerialRevoked(serial) {
+		return false
+	}
+	notBefore := int64(binary.BigEndian.Uint64(cert[serialLen : serialLen+timestampLen]))
+	notAfter := parseCertNotAfter(cert)
+	now := time.Now().Unix()
+	return now >= notBefore && now <= notAfter
+}
+
+// parseCertNotAfter extracts the notAfter field from a certLen-byte
+// certificate without verifying it, for tracking when our own
+// certificate needs renewing.
+func parseCertNotAfter(cert []byte) int64 {
+	return int64(binary.BigEndian.Uint64(cert[serialLen+timestampLen : serialLen+2*timestampLen]))
 }
 
 func (a *RelayAuth) PublicKeyHex() string {
 	return hex.EncodeToString(a.PublicKey)
 }
 
+// CurrentCertificate returns this relay's current certificate, safe
+// for concurrent use with RenewLoop replacing it.
+func (a *RelayAuth) CurrentCertificate() []byte {
+	a.certMu.RLock()
+	defer a.certMu.RUnlock()
+	return a.Certificate
+}
+
 func (a *RelayAuth) HasCertificate() bool {
-	return len(a.Certificate) == ed25519.SignatureSize
+	a.certMu.RLock()
+	defer a.certMu.RUnlock()
+	return len(a.Certificate) == certLen
 }
 
 func (a *RelayAuth) HasCA() bool {
 	return a.CAPubKey != nil
 }
 
+// certRenewMargin is how long before a certificate's notAfter
+// RenewLoop tries to fetch a replacement.
+const certRenewMargin = 10 * time.Minute
+
+// RenewLoop polls certRenewURL for a fresh certificate shortly before
+// the current one expires, persisting it to keyDir/relay.cert. A
+// revoked relay is simply refused a renewal, so its certificate stops
+// verifying once the notAfter the signing service gave it passes — no
+// CRL distribution required. An empty
+// certRenewURL disables the loop (CRLPath-based revocation only).
+func (a *RelayAuth) RenewLoop(certRenewURL string, keyDir string) {
+	if certRenewURL == "" {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		time.Sleep(a.renewDelay())
+		if err := a.renewCertificate(client, certRenewURL, keyDir); err != nil {
+			log.Printf("WARNING: certificate renewal failed, will retry: %v", err)
+		}
+	}
+}
+
+// renewDelay returns how long to wait before the next renewal
+// attempt: immediately if we have no certificate yet, otherwise
+// certRenewMargin before the current one's notAfter.
+func (a *RelayAuth) renewDelay() time.Duration {
+	a.certMu.RLock()
+	notAfter := a.certNotAfter
+	a.certMu.RUnlock()
+
+	const minDelay = 5 * time.Second
+	if notAfter == 0 {
+		return minDelay
+	}
+	d := time.Until(time.Unix(notAfter, 0).Add(-certRenewMargin))
+	if d < minDelay {
+		return minDelay
+	}
+	return d
+}
+
+// certFileReloadLoop periodically re-reads relay.cert from disk so a
+// certificate reissued out-of-band (e.g. `mesh-ca sign`) takes effect
+// without a restart. interval <= 0 disables it.
+func (a *RelayAuth) certFileReloadLoop(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.reloadCertFile()
+	}
+}
+
+// reloadCertFile re-reads a.certPath if its mtime has changed and
+// swaps in the new certificate, but only once it verifies against the
+// CA — a malformed or not-yet-fully-written file is simply skipped and
+// retried on the next tick, keeping whatever certificate was already
+// active.
+func (a *RelayAuth) reloadCertFile() {
+	if a.certPath == "" {
+		return
+	}
+	info, err := os.Stat(a.certPath)
+	if err != nil {
+		return
+	}
+	a.certMu.RLock()
+	unchanged := info.ModTime().Equal(a.certModTime)
+	a.certMu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	data, err := os.ReadFile(a.certPath)
+	if err != nil {
+		return
+	}
+	cert, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(cert) != certLen {
+		log.Printf("WARNING: relay.cert reload: malformed certificate, keeping previous")
+		return
+	}
+	if !a.VerifyCertificate(a.PublicKey, cert) {
+		log.Printf("WARNING: relay.cert reload: new certificate failed verification, keeping previous")
+		return
+	}
+
+	notAfter := parseCertNotAfter(cert)
+	a.certMu.Lock()
+	a.Certificate = cert
+	a.certNotAfter = notAfter
+	a.certModTime = info.ModTime()
+	a.certMu.Unlock()
+
+	log.Printf("Relay certificate reloaded from %s (expires %s)", a.certPath, time.Unix(notAfter, 0).UTC().Format(time.RFC3339))
+}
+
+func (a *RelayAuth) renewCertificate(client *http.Client, certRenewURL string, keyDir string) error {
+	url := certRenewURL + "?pubkey=" + a.PublicKeyHex()
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("renewal request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("renewal request: status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read renewal response: %w", err)
+	}
+	cert, err := hex.DecodeString(strings.TrimSpace(string(body)))
+	if err != nil || len(cert) != certLen {
+		return fmt.Errorf("malformed certificate from renewal service (%d bytes)", len(cert))
+	}
+	if !a.VerifyCertificate(a.PublicKey, cert) {
+		return fmt.Errorf("renewal service issued a certificate that fails verification")
+	}
+
+	certPath := filepath.Join(keyDir, "relay.cert")
+	var newModTime time.Time
+	haveModTime := false
+	if err := os.WriteFile(certPath, []byte(hex.EncodeToString(cert)), 0600); err != nil {
+		log.Printf("WARNING: failed to persist renewed certificate: %v", err)
+	} else if info, err := os.Stat(certPath); err == nil {
+		newModTime, haveModTime = info.ModTime(), true // avoid a redundant reload on the next certFileReloadLoop tick
+	}
+
+	notAfter := parseCertNotAfter(cert)
+	a.certMu.Lock()
+	a.Certificate = cert
+	a.certNotAfter = notAfter
+	if haveModTime {
+		a.certModTime = newModTime
+	}
+	a.certMu.Unlock()
+
+	log.Printf("Renewed relay certificate, valid until %s", time.Unix(notAfter, 0).UTC().Format(time.RFC3339))
+	return nil
+}
+
+// crlHeaderLen is [version:1][thisUpdate:8][nextUpdate:8][count:4] —
+// the portion of a signed CRL blob preceding the revoked serial list.
+const crlHeaderLen = 1 + 8 + 8 + 4
+const crlEntryLen = 8 // revoked entries are certificate serials, not pubkeys — see certLen
+const crlSigLen = 64
+const crlVersion = 1
+
 func (a *RelayAuth) loadCRL() {
 	if a.crlPath == "" {
 		return
@@ -176,6 +457,18 @@ func (a *RelayAuth) loadCRL() {
 		return
 	}
 
+	if a.crlLegacy {
+		a.loadLegacyCRL(data, info.ModTime())
+	} else {
+		a.loadSignedCRL(data, info.ModTime())
+	}
+}
+
+// loadLegacyCRL parses the original plain newline-delimited hex-key
+// format, with no signature or validity window. Kept behind
+// cfg.CRLLegacy for open (non-CA) deployments where there is no signer
+// to produce a signed CRL.
+func (a *RelayAuth) loadLegacyCRL(data []byte, modTime time.Time) {
 	newRevoked := make(map[string]bool)
 	for _, line := range strings.Split(string(data), "\n") {
 		line = strings.TrimSpace(strings.ToLower(line))
@@ -186,12 +479,83 @@ func (a *RelayAuth) loadCRL() {
 
 	a.mu.Lock()
 	a.revokedKeys = newRevoked
-	a.crlModTime = info.ModTime()
+	a.crlModTime = modTime
 	a.mu.Unlock()
 
 	if len(newRevoked) > 0 {
-		log.Printf("CRL reloaded: %d revoked keys", len(newRevoked))
+		log.Printf("CRL reloaded (legacy text mode): %d revoked keys", len(newRevoked))
+	}
+}
+
+// loadSignedCRL parses and verifies the signed binary CRL format:
+//
+//	[version:1][thisUpdate:8][nextUpdate:8][count:4][serial:8]*count[ca-sig:64]
+//
+// the signature covering every preceding byte. Entries are certificate
+// serials (see certLen), not pubkeys, so revoking a compromised
+// certificate doesn't also ban the relay's pubkey if it's reissued a
+// fresh cert under a new serial. A CRL that fails to verify, has
+// expired (now > nextUpdate), or is older than the one already loaded
+// (thisUpdate rollback) is rejected loudly, keeping the previous
+// revokedKeys in effect rather than silently emptying it — that would
+// be worse than a stale CRL, since it'd un-revoke every serial.
+func (a *RelayAuth) loadSignedCRL(data []byte, modTime time.Time) {
+	if a.CAPubKey == nil {
+		log.Printf("WARNING: CRL reload: no CA public key configured, cannot verify signed CRL")
+		return
+	}
+	if len(data) < crlHeaderLen+crlSigLen {
+		log.Printf("WARNING: CRL reload: file too short (%d bytes), keeping previous CRL", len(data))
+		return
+	}
+
+	if version := data[0]; version != crlVersion {
+		log.Printf("WARNING: CRL reload: unsupported version %d, keeping previous CRL", version)
+		return
 	}
+	thisUpdate := int64(binary.BigEndian.Uint64(data[1:9]))
+	nextUpdate := int64(binary.BigEndian.Uint64(data[9:17]))
+	count := binary.BigEndian.Uint32(data[17:21])
+
+	keysEnd := crlHeaderLen + int(count)*crlEntryLen
+	if keysEnd+crlSigLen != len(data) {
+		log.Printf("WARNING: CRL reload: malformed CRL (length mismatch for %d entries), keeping previous CRL", count)
+		return
+	}
+
+	signed := data[:keysEnd]
+	sig := data[keysEnd:]
+	if !ed25519.Verify(a.CAPubKey, signed, sig) {
+		log.Printf("WARNING: CRL reload: invalid CA signature, keeping previous CRL")
+		return
+	}
+
+	now := time.Now().Unix()
+	if now > nextUpdate {
+		log.Printf("WARNING: CRL reload: CRL expired (nextUpdate %s), keeping previous CRL",
+			time.Unix(nextUpdate, 0).UTC().Format(time.RFC3339))
+		return
+	}
+	if thisUpdate < a.crlThisUpdate {
+		log.Printf("WARNING: CRL reload: rejected older CRL (thisUpdate %s predates currently loaded %s), possible rollback",
+			time.Unix(thisUpdate, 0).UTC().Format(time.RFC3339), time.Unix(a.crlThisUpdate, 0).UTC().Format(time.RFC3339))
+		return
+	}
+
+	newRevoked := make(map[string]bool, count)
+	for i := 0; i < int(count); i++ {
+		off := crlHeaderLen + i*crlEntryLen
+		newRevoked[hex.EncodeToString(data[off:off+crlEntryLen])] = true
+	}
+
+	a.mu.Lock()
+	a.revokedKeys = newRevoked
+	a.crlModTime = modTime
+	a.crlThisUpdate = thisUpdate
+	a.mu.Unlock()
+
+	log.Printf("CRL reloaded: %d revoked serials (thisUpdate %s, nextUpdate %s)",
+		len(newRevoked), time.Unix(thisUpdate, 0).UTC().Format(time.RFC3339), time.Unix(nextUpdate, 0).UTC().Format(time.RFC3339))
 }
 
 func (a *RelayAuth) crlReloadLoop() {
@@ -201,3 +565,148 @@ func (a *RelayAuth) crlReloadLoop() {
 		a.loadCRL()
 	}
 }
+
+// IsRevokedHex is IsRevoked for a hex-encoded public key.
+func (a *RelayAuth) IsRevokedHex(pubKeyHex string) bool {
+	pub, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return true
+	}
+	return a.IsRevoked(pub)
+}
+
+// IsPeerRevoked reports whether a known peer should currently be
+// treated as revoked. In CA mode this checks the serial of the most
+// recent certificate RecordPeerCert saw from them — not the pubkey —
+// so revoking one certificate doesn't outlive a legitimate key
+// rotation, and so an active session is cut the moment its serial is
+// revoked rather than surviving until the next rekey. In legacy
+// (non-CA) mode there's no certificate, so it falls back to the
+// pubkey-keyed CRL.
+func (a *RelayAuth) IsPeerRevoked(pubKeyHex string) bool {
+	if !a.HasCA() {
+		return a.IsRevokedHex(pubKeyHex)
+	}
+
+	a.peersMu.Lock()
+	p, ok := a.peers[pubKeyHex]
+	a.peersMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	serial, have := p.certSerial, p.haveCertSerial
+	p.mu.Unlock()
+	if !have {
+		return false
+	}
+	return a.IsSerialRevoked(serial)
+}
+
+func (a *RelayAuth) ensurePeer(pubKeyHex string) *peerSession {
+	a.peersMu.Lock()
+	defer a.peersMu.Unlock()
+	p, ok := a.peers[pubKeyHex]
+	if !ok {
+		p = &peerSession{}
+		a.peers[pubKeyHex] = p
+	}
+	return p
+}
+
+// RecordPeerAddr remembers the transport-level address a peer was last
+// heard from, learned from the sender identity of their signed
+// envelope. It is informational only — delivery to a peer's session is
+// by broadcast, not by dialing this address.
+func (a *RelayAuth) RecordPeerAddr(pubKeyHex string, addr string) {
+	p := a.ensurePeer(pubKeyHex)
+	p.mu.Lock()
+	p.addr = addr
+	p.mu.Unlock()
+}
+
+// RecordPeerCert remembers the serial of a peer's most recently
+// verified CA certificate, learned from every signed envelope they
+// send (presence beacons included, not just handshake messages). This
+// is what lets IsPeerRevoked catch a mid-session revocation — the
+// serial backing an already-established session is kept fresh
+// independent of the handshake/rekey cycle.
+func (a *RelayAuth) RecordPeerCert(pubKeyHex string, serial uint64) {
+	p := a.ensurePeer(pubKeyHex)
+	p.mu.Lock()
+	p.certSerial = serial
+	p.haveCertSerial = true
+	p.mu.Unlock()
+}
+
+// PeerAddr returns the last known transport-level address for a peer,
+// if any.
+func (a *RelayAuth) PeerAddr(pubKeyHex string) (string, bool) {
+	a.peersMu.Lock()
+	p, ok := a.peers[pubKeyHex]
+	a.peersMu.Unlock()
+	if !ok {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr, p.addr != ""
+}
+
+// KnownPeers returns the hex pubkeys of every peer we have ever heard
+// from on the mesh.
+func (a *RelayAuth) KnownPeers() []string {
+	a.peersMu.Lock()
+	defer a.peersMu.Unlock()
+	out := make([]string, 0, len(a.peers))
+	for k := range a.peers {
+		out = append(out, k)
+	}
+	return out
+}
+
+// ActiveSession returns a peer's established Noise session, if any.
+func (a *RelayAuth) ActiveSession(pubKeyHex string) (*meshSession, bool) {
+	a.peersMu.Lock()
+	p, ok := a.peers[pubKeyHex]
+	a.peersMu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != sessionEstablished {
+		return nil, false
+	}
+	return p.session, true
+}
+
+// allocateIndex hands out a fresh local peer-index for pubKeyHex,
+// removing prevIdx's entry first (if non-zero) so a session rekey
+// doesn't leak the old index into indexPeers forever.
+func (a *RelayAuth) allocateIndex(pubKeyHex string, prevIdx uint32) uint32 {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+	if prevIdx != 0 {
+		delete(a.indexPeers, prevIdx)
+	}
+	a.nextIndex++
+	idx := a.nextIndex
+	a.indexPeers[idx] = pubKeyHex
+	return idx
+}
+
+// SessionByIndex looks up an established session by the local index we
+// handed the peer during the handshake, for O(1) demux of inbound DATA
+// packets without trying every known peer's key.
+func (a *RelayAuth) SessionByIndex(idx uint32) (*meshSession, string, bool) {
+	a.indexMu.Lock()
+	pubKeyHex, ok := a.indexPeers[idx]
+	a.indexMu.Unlock()
+	if !ok {
+		return nil, "", false
+	}
+	sess, ok := a.ActiveSession(pubKeyHex)
+	return sess, pubKeyHex, ok
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// buildSignedCRL constructs a well-formed signed CRL blob —
+// [version:1][thisUpdate:8][nextUpdate:8][count:4][serial:8]*count[ca-sig:64]
+// — signed by caPriv, for exercising loadSignedCRL without a real CA tool.
+func buildSignedCRL(caPriv ed25519.PrivateKey, thisUpdate, nextUpdate int64, serials []uint64) []byte {
+	body := make([]byte, crlHeaderLen+len(serials)*crlEntryLen)
+	body[0] = crlVersion
+	binary.BigEndian.PutUint64(body[1:9], uint64(thisUpdate))
+	binary.BigEndian.PutUint64(body[9:17], uint64(nextUpdate))
+	binary.BigEndian.PutUint32(body[17:21], uint32(len(serials)))
+	for i, serial := range serials {
+		off := crlHeaderLen + i*crlEntryLen
+		binary.BigEndian.PutUint64(body[off:off+crlEntryLen], serial)
+	}
+	sig := ed25519.Sign(caPriv, body)
+	return append(body, sig...)
+}
+
+func newTestCRLAuth(t *testing.T) (*RelayAuth, ed25519.PrivateKey) {
+	t.Helper()
+	caPub, caPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	return &RelayAuth{CAPubKey: caPub, revokedKeys: make(map[string]bool)}, caPriv
+}
+
+// TestLoadSignedCRLAcceptsValid checks that a properly signed, current
+// CRL is loaded and its serials become revoked.
+func TestLoadSignedCRLAcceptsValid(t *testing.T) {
+	a, caPriv := newTestCRLAuth(t)
+	now := time.Now().Unix()
+	blob := buildSignedCRL(caPriv, now-60, now+3600, []uint64{42, 7})
+
+	a.loadSignedCRL(blob, time.Now())
+
+	if !a.IsSerialRevoked(42) {
+		t.Fatalf("serial 42 should be revoked after loading CRL")
+	}
+	if !a.IsSerialRevoked(7) {
+		t.Fatalf("serial 7 should be revoked after loading CRL")
+	}
+	if a.IsSerialRevoked(99) {
+		t.Fatalf("serial 99 was never listed and should not be revoked")
+	}
+}
+
+// TestLoadSignedCRLRejectsBadSignature checks that a CRL signed by a
+// different key is rejected, so none of its serials become revoked.
+func TestLoadSignedCRLRejectsBadSignature(t *testing.T) {
+	a, _ := newTestCRLAuth(t)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate other key: %v", err)
+	}
+	now := time.Now().Unix()
+	blob := buildSignedCRL(otherPriv, now-60, now+3600, []uint64{42})
+
+	a.loadSignedCRL(blob, time.Now())
+
+	if a.IsSerialRevoked(42) {
+		t.Fatalf("serial 42 should not be revoked: CRL signature does not verify against CAPubKey")
+	}
+}
+
+// TestLoadSignedCRLRejectsExpired checks that a CRL past its nextUpdate
+// is rejected.
+func TestLoadSignedCRLRejectsExpired(t *testing.T) {
+	a, caPriv := newTestCRLAuth(t)
+	now := time.Now().Unix()
+	blob := buildSignedCRL(caPriv, now-7200, now-3600, []uint64{42})
+
+	a.loadSignedCRL(blob, time.Now())
+
+	if a.IsSerialRevoked(42) {
+		t.Fatalf("serial 42 should not be revoked: CRL expired before now")
+	}
+}
+
+// TestLoadSignedCRLRejectsRollback checks that a CRL with an older
+// thisUpdate than the one already loaded is rejected, keeping the
+// newer CRL's revocations in effect.
+func TestLoadSignedCRLRejectsRollback(t *testing.T) {
+	a, caPriv := newTestCRLAuth(t)
+	now := time.Now().Unix()
+
+	newer := buildSignedCRL(caPriv, now-60, now+3600, []uint64{42})
+	a.loadSignedCRL(newer, time.Now())
+	if !a.IsSerialRevoked(42) {
+		t.Fatalf("serial 42 should be revoked after loading the newer CRL")
+	}
+
+	older := buildSignedCRL(caPriv, now-120, now+1800, []uint64{7})
+	a.loadSignedCRL(older, time.Now())
+
+	if a.IsSerialRevoked(7) {
+		t.Fatalf("serial 7 should not be revoked: older CRL (rollback) must be rejected")
+	}
+	if !a.IsSerialRevoked(42) {
+		t.Fatalf("serial 42 should still be revoked: rollback attempt must not replace the current CRL")
+	}
+}
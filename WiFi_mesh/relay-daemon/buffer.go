@@ -1,53 +1,234 @@
 package main
 
-import "sync"
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
 
-// PacketBuffer is a fixed-size circular buffer that stores copies of
-// recent packets for store-and-forward delivery to newly connected clients.
-type PacketBuffer struct {
+	bolt "go.etcd.io/bbolt"
+)
+
+var bufferBucket = []byte("packets")
+
+// bufferEntry is one store-and-forward packet together with the
+// metadata PersistentBuffer needs to expire it, order it, and let a
+// reconnecting phone resume past it.
+type bufferEntry struct {
+	Seq       uint64
+	Timestamp time.Time
+	Priority  byte
+	Payload   []byte
+}
+
+// PersistentBuffer stores recent packets for store-and-forward
+// delivery to newly (re)connected clients. Each entry carries an
+// insertion timestamp, expired out of GetSince/GetAll after cfg.BufferTTL,
+// and a priority byte (the first byte of the payload, interpreted by
+// the phone app's own wire format) so control traffic replays ahead of
+// a backlog of bulk data. If cfg.BufferDBPath is set, entries are also
+// mirrored to a bbolt database so a relay restart doesn't lose the
+// buffer; leaving it empty keeps PersistentBuffer a pure in-memory
+// ring, matching the original PacketBuffer's behavior.
+type PersistentBuffer struct {
 	mu      sync.Mutex
-	packets [][]byte
-	size    int
-	head    int
-	count   int
+	entries []bufferEntry
+	maxSize int
+	ttl     time.Duration
+	nextSeq uint64
+	db      *bolt.DB
 }
 
-func NewPacketBuffer(size int) *PacketBuffer {
-	return &PacketBuffer{
-		packets: make([][]byte, size),
-		size:    size,
+func NewPersistentBuffer(cfg *Config) (*PersistentBuffer, error) {
+	b := &PersistentBuffer{
+		maxSize: cfg.BufferSize,
+		ttl:     cfg.BufferTTL,
 	}
+
+	if cfg.BufferDBPath != "" {
+		db, err := bolt.Open(cfg.BufferDBPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("open buffer db: %w", err)
+		}
+		b.db = db
+		if err := b.loadFromDisk(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("load buffer db: %w", err)
+		}
+		log.Printf("Store-and-forward buffer persisted to %s (%d packets restored)", cfg.BufferDBPath, len(b.entries))
+	}
+
+	return b, nil
 }
 
-func (pb *PacketBuffer) Add(data []byte) {
-	pb.mu.Lock()
-	defer pb.mu.Unlock()
+// Add records a packet, expiring the oldest in-memory entry once
+// maxSize is exceeded and mirroring to disk if persistence is enabled.
+func (b *PersistentBuffer) Add(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	pkt := make([]byte, len(data))
-	copy(pkt, data)
+	b.nextSeq++
+	entry := bufferEntry{
+		Seq:       b.nextSeq,
+		Timestamp: time.Now(),
+		Priority:  priorityOf(data),
+		Payload:   append([]byte(nil), data...),
+	}
 
-	pb.packets[pb.head] = pkt
-	pb.head = (pb.head + 1) % pb.size
-	if pb.count < pb.size {
-		pb.count++
+	b.entries = append(b.entries, entry)
+	if len(b.entries) > b.maxSize {
+		b.entries = b.entries[1:]
 	}
+
+	if b.db != nil {
+		if err := b.persist(entry); err != nil {
+			log.Printf("buffer: persist to disk failed: %v", err)
+		}
+	}
+}
+
+// priorityOf extracts a best-effort replay priority from the first
+// byte of an application payload: lower values are replayed first, so
+// a low-numbered control opcode in the phone app's wire format reaches
+// a reconnecting client ahead of a backlog of ordinary message
+// traffic. An empty payload sorts last.
+func priorityOf(data []byte) byte {
+	if len(data) == 0 {
+		return 0xff
+	}
+	return data[0]
 }
 
-// GetAll returns buffered packets in oldest-first order.
-func (pb *PacketBuffer) GetAll() [][]byte {
-	pb.mu.Lock()
-	defer pb.mu.Unlock()
+// GetAll returns every buffered, non-expired packet, equivalent to
+// GetSince(0). Kept for compatibility with callers that don't have a
+// resume cursor to offer.
+func (b *PersistentBuffer) GetAll() [][]byte {
+	return b.GetSince(0)
+}
+
+// GetSince returns buffered, non-expired packets with a sequence
+// number greater than cursor — the delta a reconnecting phone missed
+// while it was offline — ordered by priority (ascending) and then by
+// arrival order within the same priority.
+func (b *PersistentBuffer) GetSince(cursor uint64) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var cutoff time.Time
+	if b.ttl > 0 {
+		cutoff = time.Now().Add(-b.ttl)
+	}
+
+	live := make([]bufferEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		if e.Seq <= cursor {
+			continue
+		}
+		if b.ttl > 0 && e.Timestamp.Before(cutoff) {
+			continue
+		}
+		live = append(live, e)
+	}
 
-	result := make([][]byte, 0, pb.count)
-	start := pb.head - pb.count
-	if start < 0 {
-		start += pb.size
+	sort.SliceStable(live, func(i, j int) bool {
+		return live[i].Priority < live[j].Priority
+	})
+
+	out := make([][]byte, len(live))
+	for i, e := range live {
+		out[i] = e.Payload
 	}
-	for i := 0; i < pb.count; i++ {
-		idx := (start + i) % pb.size
-		if pb.packets[idx] != nil {
-			result = append(result, pb.packets[idx])
+	return out
+}
+
+func (b *PersistentBuffer) Close() error {
+	if b.db == nil {
+		return nil
+	}
+	return b.db.Close()
+}
+
+// persist writes entry to the bbolt bucket, trimming the oldest
+// records once the on-disk count exceeds maxSize so the db stays
+// bounded the same way the in-memory ring does.
+func (b *PersistentBuffer) persist(entry bufferEntry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bufferBucket)
+		if err != nil {
+			return err
+		}
+
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], entry.Seq)
+		if err := bucket.Put(key[:], encodeBufferEntry(entry)); err != nil {
+			return err
 		}
+
+		// bucket.Stats().KeyN doesn't reflect the Put above — bbolt only
+		// refreshes bucket stats at commit, not mid-transaction — so
+		// account for the just-inserted key explicitly or this always
+		// leaves the bucket one entry over maxSize.
+		excess := bucket.Stats().KeyN + 1 - b.maxSize
+		cur := bucket.Cursor()
+		for k, _ := cur.First(); k != nil && excess > 0; k, _ = cur.Next() {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			excess--
+		}
+		return nil
+	})
+}
+
+// loadFromDisk replays the bbolt bucket into the in-memory ring on
+// startup. Keys are big-endian sequence numbers, so iteration is
+// already oldest-first.
+func (b *PersistentBuffer) loadFromDisk() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bufferBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			entry, err := decodeBufferEntry(v)
+			if err != nil {
+				log.Printf("buffer: skipping corrupt entry on load: %v", err)
+				return nil
+			}
+			b.entries = append(b.entries, entry)
+			if entry.Seq > b.nextSeq {
+				b.nextSeq = entry.Seq
+			}
+			return nil
+		})
+	})
+}
+
+// encodeBufferEntry packs an entry as [seq:8][unixNano:8][priority:1][len:4][payload]
+// for bbolt storage.
+func encodeBufferEntry(e bufferEntry) []byte {
+	buf := make([]byte, 21+len(e.Payload))
+	binary.BigEndian.PutUint64(buf[0:8], e.Seq)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(e.Timestamp.UnixNano()))
+	buf[16] = e.Priority
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(e.Payload)))
+	copy(buf[21:], e.Payload)
+	return buf
+}
+
+func decodeBufferEntry(data []byte) (bufferEntry, error) {
+	if len(data) < 21 {
+		return bufferEntry{}, fmt.Errorf("buffer entry too short: %d bytes", len(data))
+	}
+	seq := binary.BigEndian.Uint64(data[0:8])
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(data[8:16])))
+	priority := data[16]
+	plen := binary.BigEndian.Uint32(data[17:21])
+	if int(21+plen) > len(data) {
+		return bufferEntry{}, fmt.Errorf("buffer entry payload length overflows record")
 	}
-	return result
+	payload := append([]byte(nil), data[21:21+plen]...)
+	return bufferEntry{Seq: seq, Timestamp: ts, Priority: priority, Payload: payload}, nil
 }
@@ -12,9 +12,21 @@ type writeMsg struct {
 	payload   []byte
 }
 
+// clientUrgentQueueDepth bounds the disco/control sub-queue. It stays
+// small and separate from the data queue so a PING/PONG (or a future
+// disco frame) never queues behind a backlog of DATA frames from a slow
+// phone.
+const clientUrgentQueueDepth = 8
+
+// isUrgentFrame reports whether a frame type belongs on the small
+// priority sub-queue rather than the bulk DATA queue.
+func isUrgentFrame(frameType byte) bool {
+	return frameType != FrameData
+}
+
 // Client represents a single phone connected over TLS.
-// All writes are serialized through writeCh → WriteLoop goroutine
-// to avoid concurrent conn.Write calls.
+// All writes are serialized through a per-client sendQueue → WriteLoop
+// goroutine to avoid concurrent conn.Write calls.
 type Client struct {
 	conn       *tls.Conn
 	peerID     string
@@ -23,17 +35,20 @@ type Client struct {
 	lastActive time.Time
 	mu         sync.Mutex
 	closed     bool
-	writeCh    chan writeMsg
+	queue      *sendQueue
+	metrics    ClientMetrics
+	router     *Router // for rolling this client's counters into global metrics
 }
 
-func NewClient(conn *tls.Conn, peerID string, cfg *Config) *Client {
+func NewClient(conn *tls.Conn, peerID string, cfg *Config, router *Router) *Client {
 	return &Client{
 		conn:       conn,
 		peerID:     peerID,
 		addr:       conn.RemoteAddr().String(),
 		limiter:    NewTokenBucket(cfg.ClientPacketsPerSec, cfg.ClientBurstSize),
 		lastActive: time.Now(),
-		writeCh:    make(chan writeMsg, 64),
+		queue:      newSendQueue(cfg.SendQueueDepth),
+		router:     router,
 	}
 }
 
@@ -78,32 +93,51 @@ func (c *Client) ReadLoop(router *Router, cfg *Config) {
 	}
 }
 
-// WriteLoop drains writeCh and sends frames to the phone.
-// Exits when writeCh is closed (via Client.Close).
+// WriteLoop drains the send queue and writes frames to the phone.
+// Exits when the queue is closed (via Client.Close).
 func (c *Client) WriteLoop() {
-	for msg := range c.writeCh {
+	for {
+		msg, ok := c.queue.pop()
+		if !ok {
+			return
+		}
 		if err := WriteFrame(c.conn, msg.frameType, msg.payload); err != nil {
 			log.Printf("[%s] write: %v", c.addr, err)
 			c.Close()
 			return
 		}
+		c.metrics.incSent()
+		if c.router != nil {
+			c.router.metrics.incSent()
+		}
 	}
 }
 
-// Send enqueues a frame for the WriteLoop. Non-blocking: drops if the
-// channel is full (back-pressure on a slow client).
-// Mutex is held through the select to prevent a concurrent Close()
-// from closing the channel between the flag check and the send.
+// Send enqueues a frame for the WriteLoop. DATA frames land on the
+// bounded data queue with head-drop backpressure; everything else
+// (handshake/keepalive/disco frames) goes on the small urgent queue.
 func (c *Client) Send(frameType byte, payload []byte) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.closed {
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
 		return
 	}
-	select {
-	case c.writeCh <- writeMsg{frameType, payload}:
-	default:
-		log.Printf("[%s] write buffer full, dropping frame", c.addr)
+
+	dropped, droppedHead := c.queue.push(writeMsg{frameType, payload})
+	switch {
+	case droppedHead:
+		c.metrics.incDroppedHead()
+		if c.router != nil {
+			c.router.metrics.incDroppedHead()
+		}
+		log.Printf("[%s] data queue full, dropped oldest frame", c.addr)
+	case dropped:
+		c.metrics.incDroppedQueueFull()
+		if c.router != nil {
+			c.router.metrics.incDroppedQueueFull()
+		}
+		log.Printf("[%s] urgent queue full, dropping frame 0x%02x", c.addr, frameType)
 	}
 }
 
@@ -111,6 +145,12 @@ func (c *Client) SendData(data []byte) {
 	c.Send(FrameData, data)
 }
 
+// QueueDepth returns the number of frames currently queued for this
+// client (urgent + data), for /debug/vars reporting.
+func (c *Client) QueueDepth() int {
+	return c.queue.depth()
+}
+
 func (c *Client) Close() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -118,6 +158,88 @@ func (c *Client) Close() {
 		return
 	}
 	c.closed = true
-	close(c.writeCh)
+	c.queue.close()
 	c.conn.Close()
 }
+
+// sendQueue is a bounded, mutex-guarded double queue: a small urgent
+// queue for disco/control frames, and a larger data queue for DATA
+// frames. When the data queue is full, the *oldest* entry is dropped
+// (head-drop) so the newest gossip always gets a slot — unlike a plain
+// channel-backed queue, which would reject the newest arrival instead.
+type sendQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	urgent  []writeMsg
+	data    []writeMsg
+	maxData int
+	closed  bool
+}
+
+func newSendQueue(maxData int) *sendQueue {
+	q := &sendQueue{maxData: maxData}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push enqueues msg. It reports (dropped, droppedHead): dropped is true
+// if msg itself was rejected (urgent queue full) or if head-drop made
+// room for it (data queue full); droppedHead distinguishes the two.
+func (q *sendQueue) push(msg writeMsg) (dropped, droppedHead bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false, false
+	}
+
+	if isUrgentFrame(msg.frameType) {
+		if len(q.urgent) >= clientUrgentQueueDepth {
+			return true, false
+		}
+		q.urgent = append(q.urgent, msg)
+		q.cond.Signal()
+		return false, false
+	}
+
+	if len(q.data) >= q.maxData {
+		q.data = q.data[1:]
+		droppedHead = true
+	}
+	q.data = append(q.data, msg)
+	q.cond.Signal()
+	return droppedHead, droppedHead
+}
+
+// pop blocks until a message is available or the queue is closed.
+// Urgent messages are always served before data messages.
+func (q *sendQueue) pop() (writeMsg, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.urgent) == 0 && len(q.data) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.urgent) > 0 {
+		msg := q.urgent[0]
+		q.urgent = q.urgent[1:]
+		return msg, true
+	}
+	if len(q.data) > 0 {
+		msg := q.data[0]
+		q.data = q.data[1:]
+		return msg, true
+	}
+	return writeMsg{}, false
+}
+
+func (q *sendQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.urgent) + len(q.data)
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,304 @@
+// Command mesh-ca manages the Ed25519 certificate authority that signs
+// relay-daemon's short-lived relay certificates and revocation lists.
+// It has three verbs:
+//
+//	mesh-ca init                    generate a new CA keypair
+//	mesh-ca sign <relay_pubkey>      issue a short-lived certificate
+//	mesh-ca revoke <serial>          add a certificate serial to the signed CRL
+//
+// The certificate and CRL binary formats here must stay in lockstep
+// with RelayAuth.VerifyCertificate and RelayAuth.loadSignedCRL in
+// relay-daemon/auth.go.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serialLen    = 8
+	timestampLen = 8
+	sigLen       = 64
+	certLen      = serialLen + 2*timestampLen + sigLen // [serial][notBefore][notAfter][ca-sig]
+
+	crlHeaderLen = 1 + 8 + 8 + 4 // [version][thisUpdate][nextUpdate][count]
+	crlEntryLen  = 8             // revoked entries are certificate serials, not pubkeys
+	crlSigLen    = 64
+	crlVersion   = 1
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = cmdInit(os.Args[2:])
+	case "sign":
+		err = cmdSign(os.Args[2:])
+	case "revoke":
+		err = cmdRevoke(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("mesh-ca %s: %v", os.Args[1], err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mesh-ca init [-ca-dir dir]")
+	fmt.Fprintln(os.Stderr, "       mesh-ca sign [-ca-dir dir] [-valid-for dur] <relay_pubkey_hex_or_file>")
+	fmt.Fprintln(os.Stderr, "       mesh-ca revoke [-ca-dir dir] [-crl-valid-for dur] <serial>")
+}
+
+func cmdInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	caDir := fs.String("ca-dir", ".", "directory to write ca.key/ca.pub into")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*caDir, 0700); err != nil {
+		return fmt.Errorf("create ca dir: %w", err)
+	}
+
+	keyPath := filepath.Join(*caDir, "ca.key")
+	if _, err := os.Stat(keyPath); err == nil {
+		return fmt.Errorf("%s already exists, refusing to overwrite", keyPath)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate ed25519 key: %w", err)
+	}
+	if err := os.WriteFile(keyPath, []byte(priv), 0600); err != nil {
+		return fmt.Errorf("write ca.key: %w", err)
+	}
+	pubPath := filepath.Join(*caDir, "ca.pub")
+	if err := os.WriteFile(pubPath, []byte(hex.EncodeToString(pub)+"\n"), 0644); err != nil {
+		return fmt.Errorf("write ca.pub: %w", err)
+	}
+
+	fmt.Printf("CA keypair generated: %s, %s\n", keyPath, pubPath)
+	fmt.Printf("CA public key (for --ca-pubkey): %s\n", hex.EncodeToString(pub))
+	return nil
+}
+
+func cmdSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	caDir := fs.String("ca-dir", ".", "directory containing ca.key and ca.serial")
+	validFor := fs.Duration("valid-for", 24*time.Hour, "how long the issued certificate remains valid")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one relay pubkey argument")
+	}
+
+	caPriv, err := loadCAKey(*caDir)
+	if err != nil {
+		return err
+	}
+	relayPub, err := readPubKeyArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Revocation is keyed on the certificate serial, not the relay
+	// pubkey (see the package doc comment), so there's nothing to check
+	// the pubkey against here — a relay whose previous certificate was
+	// revoked can be validly reissued a new one under a fresh serial,
+	// which is exactly what makes key rotation after a compromise safe.
+	serial, err := nextSerial(*caDir)
+	if err != nil {
+		return fmt.Errorf("allocate serial: %w", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(*validFor)
+
+	cert := make([]byte, certLen)
+	binary.BigEndian.PutUint64(cert[:serialLen], serial)
+	binary.BigEndian.PutUint64(cert[serialLen:serialLen+timestampLen], uint64(now.Unix()))
+	binary.BigEndian.PutUint64(cert[serialLen+timestampLen:serialLen+2*timestampLen], uint64(notAfter.Unix()))
+	signed := make([]byte, 0, serialLen+2*timestampLen+len(relayPub))
+	signed = append(signed, cert[:serialLen+2*timestampLen]...)
+	signed = append(signed, relayPub...)
+	sig := ed25519.Sign(caPriv, signed)
+	copy(cert[serialLen+2*timestampLen:], sig)
+
+	fmt.Println(hex.EncodeToString(cert))
+	fmt.Fprintf(os.Stderr, "Issued certificate serial %d for %s, valid until %s\n",
+		serial, hex.EncodeToString(relayPub), notAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintln(os.Stderr, "Write the line above to the relay's relay.cert file.")
+	return nil
+}
+
+func cmdRevoke(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	caDir := fs.String("ca-dir", ".", "directory containing ca.key and revoked.crl")
+	crlValidFor := fs.Duration("crl-valid-for", 24*time.Hour, "how long the reissued CRL remains valid before it must be refreshed")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one certificate serial argument")
+	}
+
+	serial, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid serial %q: %w", fs.Arg(0), err)
+	}
+
+	caPriv, err := loadCAKey(*caDir)
+	if err != nil {
+		return err
+	}
+
+	crlPath := filepath.Join(*caDir, "revoked.crl")
+	revoked, prevThisUpdate, err := readSignedCRL(crlPath, ed25519.PrivateKey(caPriv).Public().(ed25519.PublicKey))
+	if err != nil {
+		return fmt.Errorf("read existing CRL: %w", err)
+	}
+
+	var buf [serialLen]byte
+	binary.BigEndian.PutUint64(buf[:], serial)
+	key := hex.EncodeToString(buf[:])
+	revoked[key] = true
+
+	now := time.Now().Unix()
+	thisUpdate := now
+	if thisUpdate <= prevThisUpdate {
+		thisUpdate = prevThisUpdate + 1
+	}
+	nextUpdate := now + int64(*crlValidFor/time.Second)
+
+	entries := make([]string, 0, len(revoked))
+	for k := range revoked {
+		entries = append(entries, k)
+	}
+
+	blob := make([]byte, crlHeaderLen+len(entries)*crlEntryLen+crlSigLen)
+	blob[0] = crlVersion
+	binary.BigEndian.PutUint64(blob[1:9], uint64(thisUpdate))
+	binary.BigEndian.PutUint64(blob[9:17], uint64(nextUpdate))
+	binary.BigEndian.PutUint32(blob[17:21], uint32(len(entries)))
+	for i, e := range entries {
+		raw, err := hex.DecodeString(e)
+		if err != nil || len(raw) != crlEntryLen {
+			return fmt.Errorf("corrupt revoked-serial entry %q", e)
+		}
+		off := crlHeaderLen + i*crlEntryLen
+		copy(blob[off:off+crlEntryLen], raw)
+	}
+	entriesEnd := crlHeaderLen + len(entries)*crlEntryLen
+	sig := ed25519.Sign(caPriv, blob[:entriesEnd])
+	copy(blob[entriesEnd:], sig)
+
+	if err := os.WriteFile(crlPath, blob, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", crlPath, err)
+	}
+
+	fmt.Printf("Revoked serial %d; CRL now lists %d entry(ies), valid until %s\n",
+		serial, len(entries), time.Unix(nextUpdate, 0).UTC().Format(time.RFC3339))
+	return nil
+}
+
+func loadCAKey(caDir string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filepath.Join(caDir, "ca.key"))
+	if err != nil {
+		return nil, fmt.Errorf("read ca.key: %w", err)
+	}
+	if len(data) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("ca.key is %d bytes, expected %d", len(data), ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(data), nil
+}
+
+// readPubKeyArg accepts either a path to a file containing a hex-encoded
+// pubkey or the hex string itself, mirroring how --ca-pubkey is passed
+// directly as hex on the relay-daemon command line.
+func readPubKeyArg(arg string) ([]byte, error) {
+	s := arg
+	if data, err := os.ReadFile(arg); err == nil {
+		s = string(data)
+	}
+	s = strings.TrimSpace(s)
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid relay pubkey hex (need %d bytes)", ed25519.PublicKeySize)
+	}
+	return raw, nil
+}
+
+// nextSerial allocates the next certificate serial from ca.serial, a
+// decimal counter file kept next to ca.key, creating it at 0 if absent.
+// Serials only ever increase, so two certs never collide even across
+// separate mesh-ca invocations against the same CA directory.
+func nextSerial(caDir string) (uint64, error) {
+	path := filepath.Join(caDir, "ca.serial")
+
+	var next uint64
+	if data, err := os.ReadFile(path); err == nil {
+		issued, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("corrupt %s: %w", path, err)
+		}
+		next = issued + 1
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.FormatUint(next, 10)+"\n"), 0600); err != nil {
+		return 0, fmt.Errorf("write %s: %w", path, err)
+	}
+	return next, nil
+}
+
+// readSignedCRL reads and verifies the existing CRL at path, returning
+// its revoked-serial set and thisUpdate so revoke can extend it. A
+// missing file is treated as an empty CRL; any other error is returned
+// so a corrupt or unverifiable CRL can't be silently clobbered.
+func readSignedCRL(path string, caPub ed25519.PublicKey) (map[string]bool, int64, error) {
+	revoked := make(map[string]bool)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return revoked, 0, nil
+		}
+		return nil, 0, err
+	}
+	if len(data) < crlHeaderLen+crlSigLen {
+		return nil, 0, fmt.Errorf("CRL file too short")
+	}
+	if data[0] != crlVersion {
+		return nil, 0, fmt.Errorf("unsupported CRL version %d", data[0])
+	}
+	thisUpdate := int64(binary.BigEndian.Uint64(data[1:9]))
+	count := binary.BigEndian.Uint32(data[17:21])
+
+	entriesEnd := crlHeaderLen + int(count)*crlEntryLen
+	if entriesEnd+crlSigLen != len(data) {
+		return nil, 0, fmt.Errorf("CRL length mismatch for %d entries", count)
+	}
+	if !ed25519.Verify(caPub, data[:entriesEnd], data[entriesEnd:]) {
+		return nil, 0, fmt.Errorf("CRL signature does not verify against ca.key")
+	}
+
+	for i := 0; i < int(count); i++ {
+		off := crlHeaderLen + i*crlEntryLen
+		revoked[hex.EncodeToString(data[off:off+crlEntryLen])] = true
+	}
+	return revoked, thisUpdate, nil
+}
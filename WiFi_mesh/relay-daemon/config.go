@@ -10,6 +10,41 @@ type Config struct {
 	MeshMulticast string
 	CertDir       string
 
+	// ACMEDomain enables Let's-Encrypt-style automatic TLS certificates
+	// via golang.org/x/crypto/acme/autocert for the phone-facing
+	// listener, in place of the self-signed cert in CertDir. Empty
+	// disables it (the default).
+	ACMEDomain string
+	ACMEEmail  string
+	// ACMECache is the directory autocert persists issued certificates
+	// and account keys to across restarts.
+	ACMECache string
+
+	// TLSCertReloadInterval controls how often the self-signed-mode TLS
+	// listener re-reads relay.crt/relay.key from CertDir, picking up an
+	// operator-rotated certificate without a restart. Zero disables
+	// reloading (the cert loaded at startup is used forever).
+	TLSCertReloadInterval time.Duration
+
+	// MeshTransport selects the MeshLink backend: "multicast" (default,
+	// batman-adv UDP multicast), "quic", or "libp2p".
+	MeshTransport string
+
+	// MeshQUICListen is the local address the quic transport listens
+	// on, and MeshQUICPeers is the seed address list it dials and keeps
+	// connected. The transport reaches only these seeds and peers that
+	// dial in; it learns no other peer addresses.
+	MeshQUICListen string
+	MeshQUICPeers  []string
+
+	// MeshLibp2pListen is a libp2p multiaddr to listen on, MeshLibp2pPeers
+	// is a seed list of peer multiaddrs to connect to, and
+	// MeshLibp2pTopic is the gossipsub topic relays publish/subscribe
+	// to (defaults to defaultLibp2pTopic if empty).
+	MeshLibp2pListen string
+	MeshLibp2pPeers  []string
+	MeshLibp2pTopic  string
+
 	// Capacity
 	MaxClients    int
 	MaxPacketSize int
@@ -22,12 +57,37 @@ type Config struct {
 	GlobalPacketsPerSec float64
 	GlobalBurstSize     int
 
-	// Proof of Work
+	// Proof of Work (static baseline; AdmissionController adapts above it)
 	PoWDifficulty uint8
 
+	// PoWMaxDifficulty caps the adaptive difficulty AdmissionController
+	// will ever hand out, regardless of connection pressure.
+	PoWMaxDifficulty uint8
+
+	// PoWSoftThreshold is the handshake-attempts/sec EWMA above which
+	// AdmissionController starts raising difficulty. Zero disables the
+	// rate-based component.
+	PoWSoftThreshold float64
+
 	// Store-and-forward buffer
 	BufferSize int
 
+	// BufferTTL bounds how long a buffered packet is eligible for
+	// replay to a (re)connecting client. Zero disables expiry.
+	BufferTTL time.Duration
+
+	// BufferDBPath is an optional bbolt database path that mirrors the
+	// store-and-forward buffer to disk so a relay restart doesn't lose
+	// it. Empty keeps the buffer in-memory only.
+	BufferDBPath string
+
+	// Per-client send queue depth (DATA frames; head-drop on overflow)
+	SendQueueDepth int
+
+	// Loopback address for the /debug/vars metrics endpoint, e.g.
+	// "127.0.0.1:9090". Empty disables the metrics server.
+	MetricsAddr string
+
 	// Deduplication
 	DedupMaxEntries int
 
@@ -43,6 +103,28 @@ type Config struct {
 	KeyDir   string
 	CAPubKey string // hex-encoded CA public key; empty = open/legacy mode
 	CRLPath  string // path to certificate revocation list file
+
+	// CRLLegacy selects the original plain newline-delimited hex-key CRL
+	// format, with no signature or validity window, instead of the
+	// signed binary format. Only meaningful for open (non-CA)
+	// deployments where there is no CA key to sign a CRL with.
+	CRLLegacy bool
+
+	// CertRenewURL is the HTTPS endpoint RelayAuth.RenewLoop polls for a
+	// fresh short-lived certificate before the current one expires.
+	// Empty disables renewal, leaving CRLPath as the only revocation
+	// mechanism.
+	CertRenewURL string
+
+	// CertReloadInterval controls how often relay.cert is re-read from
+	// disk, picking up a certificate reissued by `mesh-ca sign` without
+	// a restart. Zero disables reloading.
+	CertReloadInterval time.Duration
+
+	// MaxAttestationAge bounds how old a mesh envelope's stapled
+	// timestamp may be before MeshLink.RecvLoop rejects it. Zero
+	// disables the check.
+	MaxAttestationAge time.Duration
 }
 
 func DefaultConfig() *Config {
@@ -53,6 +135,19 @@ func DefaultConfig() *Config {
 		MeshMulticast: "239.0.7.2",
 		CertDir:       "/etc/bitchat",
 
+		ACMEDomain: "",
+		ACMEEmail:  "",
+		ACMECache:  "/etc/bitchat/acme-cache",
+
+		TLSCertReloadInterval: 5 * time.Minute,
+
+		MeshTransport: "multicast",
+
+		MeshQUICListen: ":7277",
+
+		MeshLibp2pListen: "/ip4/0.0.0.0/udp/7278/quic-v1",
+		MeshLibp2pTopic:  defaultLibp2pTopic,
+
 		MaxClients:    20,
 		MaxPacketSize: 65536,
 
@@ -61,11 +156,18 @@ func DefaultConfig() *Config {
 		GlobalPacketsPerSec: 100,
 		GlobalBurstSize:     200,
 
-		PoWDifficulty: 20,
+		PoWDifficulty:    20,
+		PoWMaxDifficulty: 28,
+		PoWSoftThreshold: 5.0,
 
 		BufferSize:      1000,
+		BufferTTL:       5 * time.Minute,
+		BufferDBPath:    "",
 		DedupMaxEntries: 10000,
 
+		SendQueueDepth: 64,
+		MetricsAddr:    "",
+
 		KeepaliveInterval: 30 * time.Second,
 		KeepaliveTimeout:  90 * time.Second,
 		HandshakeTimeout:  30 * time.Second,
@@ -74,5 +176,10 @@ func DefaultConfig() *Config {
 		KeyDir:            "/etc/bitchat",
 		CAPubKey:          "",
 		CRLPath:           "/etc/bitchat/revoked.crl",
+		CRLLegacy:         false,
+
+		CertRenewURL:       "",
+		CertReloadInterval: 5 * time.Minute,
+		MaxAttestationAge:  5 * time.Minute,
 	}
 }
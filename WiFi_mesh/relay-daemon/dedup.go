@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"sync"
 )
 
@@ -15,34 +16,126 @@ func PacketHash(data []byte) [8]byte {
 	return id
 }
 
-// DedupFilter tracks recently seen packet hashes. When the map reaches
-// maxEntries it is cleared entirely — a brief window of potential
-// re-delivery that the app-level gossip dedup already handles.
+// dedupHashCount is the number of bits set per insertion (k).
+const dedupHashCount = 7
+
+// bloomBitsPerEntry is m/n: each filter gets 14.4 bits per maxEntries,
+// i.e. 144000 bits (~18KB) per filter at maxEntries=10000. A filter
+// takes at most maxEntries/2 insertions before it is cleared, so with
+// k=7 a lookup (which tests both filters) has a false-positive rate of
+// at most about 4 in 100,000.
+const bloomBitsPerEntry = 14.4
+
+// DedupFilter tracks recently seen packet hashes using a rotating pair
+// of Bloom filters (double buffering) instead of a map that gets
+// wiped outright when full. Hashes are inserted into the "active"
+// filter; a lookup checks both active and standby, so a hash inserted
+// just before a rotation is still recognized afterward. Every
+// maxEntries/2 insertions the active and standby filters swap and the
+// new active is zeroed — fixed memory, O(1) operations, and no more
+// full-flush window where a just-forgotten hash can be re-delivered.
 type DedupFilter struct {
-	mu   sync.Mutex
-	seen map[[8]byte]struct{}
-	max  int
+	mu        sync.Mutex
+	filters   [2]*bloomFilter
+	active    int
+	inserted  int
+	swapEvery int
 }
 
 func NewDedupFilter(maxEntries int) *DedupFilter {
+	if maxEntries < 2 {
+		maxEntries = 2
+	}
+	bits := uint64(float64(maxEntries) * bloomBitsPerEntry)
 	return &DedupFilter{
-		seen: make(map[[8]byte]struct{}, maxEntries),
-		max:  maxEntries,
+		filters:   [2]*bloomFilter{newBloomFilter(bits), newBloomFilter(bits)},
+		swapEvery: maxEntries / 2,
 	}
 }
 
-// IsDuplicate returns true if this hash was already seen.
-// Otherwise it records the hash and returns false.
+// IsDuplicate returns true if this hash was already seen (in either
+// the active or standby filter). Otherwise it records the hash in the
+// active filter and returns false.
 func (d *DedupFilter) IsDuplicate(hash [8]byte) bool {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if _, exists := d.seen[hash]; exists {
+	h1, h2 := hashHalves(hash)
+	active := d.filters[d.active]
+	standby := d.filters[1-d.active]
+
+	if active.test(h1, h2) || standby.test(h1, h2) {
 		return true
 	}
-	if len(d.seen) >= d.max {
-		d.seen = make(map[[8]byte]struct{}, d.max)
+	active.set(h1, h2)
+
+	d.inserted++
+	if d.inserted >= d.swapEvery {
+		d.active = 1 - d.active
+		d.filters[d.active].reset()
+		d.inserted = 0
 	}
-	d.seen[hash] = struct{}{}
 	return false
 }
+
+// hashHalves derives the pair of 64-bit hashes used for
+// Kirsch-Mitzenmacher double hashing (h_i = h1 + i·h2). The dedup
+// interface only carries the 8-byte truncated packet ID, not the full
+// SHA-256, so h2 is derived from h1 by running it through a splitmix64
+// mix rather than taking a second, independent slice of the digest.
+func hashHalves(hash [8]byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(hash[:])
+	h2 := splitmix64(h1)
+	if h2 == 0 {
+		h2 = 1 // avoid degenerating to a single probed slot
+	}
+	return h1, h2
+}
+
+// splitmix64 is a fast, well-distributed 64-bit mixing function.
+func splitmix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// bloomFilter is a fixed-size bit array checked/set by dedupHashCount
+// double-hashed positions. It has no false negatives and a bounded
+// false-positive rate determined by its size relative to the number of
+// items inserted.
+type bloomFilter struct {
+	bits  []uint64
+	nbits uint64
+}
+
+func newBloomFilter(nbits uint64) *bloomFilter {
+	if nbits == 0 {
+		nbits = 64
+	}
+	words := (nbits + 63) / 64
+	return &bloomFilter{bits: make([]uint64, words), nbits: words * 64}
+}
+
+func (f *bloomFilter) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < dedupHashCount; i++ {
+		idx := (h1 + i*h2) % f.nbits
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) set(h1, h2 uint64) {
+	for i := uint64(0); i < dedupHashCount; i++ {
+		idx := (h1 + i*h2) % f.nbits
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
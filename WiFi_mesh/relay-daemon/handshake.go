@@ -11,96 +11,112 @@ import (
 
 // PerformHandshake executes the server side of the connection handshake:
 //
-//	Client → HELLO  (version + peer ID)
+//	Client → HELLO  (version + peer ID + optional cert hash + resume cursor)
 //	Server → CHALLENGE (nonce + difficulty)
 //	Client → SOLUTION  (uint64 PoW answer)
 //	Server → ACCEPT | REJECT
 //
-// Returns the peer ID on success.
-func PerformHandshake(conn net.Conn, cfg *Config) (string, error) {
+// The CHALLENGE difficulty is computed by admission from current
+// connection pressure rather than a static config value. Returns the
+// peer ID and the phone's advertised store-and-forward resume cursor
+// (0 if it didn't send one) on success.
+func PerformHandshake(conn net.Conn, cfg *Config, admission *AdmissionController, clientCount int) (string, uint64, error) {
 	conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout))
 	defer conn.SetDeadline(time.Time{}) // clear deadline after handshake
 
 	// --- Step 1: Read HELLO ---
 	hello, err := ReadFrame(conn, cfg.MaxPacketSize)
 	if err != nil {
-		return "", fmt.Errorf("read HELLO: %w", err)
+		return "", 0, fmt.Errorf("read HELLO: %w", err)
 	}
 	if hello.Type != FrameHello {
-		return "", fmt.Errorf("expected HELLO (0x%02x), got 0x%02x", FrameHello, hello.Type)
+		return "", 0, fmt.Errorf("expected HELLO (0x%02x), got 0x%02x", FrameHello, hello.Type)
 	}
 	if len(hello.Payload) < 3 {
-		return "", fmt.Errorf("HELLO too short: %d bytes", len(hello.Payload))
+		return "", 0, fmt.Errorf("HELLO too short: %d bytes", len(hello.Payload))
 	}
 
 	version := binary.BigEndian.Uint16(hello.Payload[0:2])
 	peerIDLen := int(hello.Payload[2])
 	if 3+peerIDLen > len(hello.Payload) {
-		return "", fmt.Errorf("HELLO peer-ID length overflows payload")
+		return "", 0, fmt.Errorf("HELLO peer-ID length overflows payload")
 	}
 	peerID := string(hello.Payload[3 : 3+peerIDLen])
 
 	if version != ProtocolVersion {
 		_ = WriteFrame(conn, FrameReject, []byte(fmt.Sprintf("unsupported version %d", version)))
-		return "", fmt.Errorf("unsupported protocol version %d", version)
+		return "", 0, fmt.Errorf("unsupported protocol version %d", version)
 	}
 
 	// --- Step 1b: Verify APK cert hash (if enforcement is enabled) ---
 	certHashOffset := 3 + peerIDLen
+	resumeCursorOffset := certHashOffset
 	if certHashOffset+32 <= len(hello.Payload) {
 		certHash := hello.Payload[certHashOffset : certHashOffset+32]
 		certHashHex := hex.EncodeToString(certHash)
 		if len(cfg.AllowedCertHashes) > 0 {
 			if !cfg.AllowedCertHashes[certHashHex] {
 				_ = WriteFrame(conn, FrameReject, []byte("certificate not authorized"))
-				return "", fmt.Errorf("rejected cert hash %s from peer %s", certHashHex, peerID)
+				return "", 0, fmt.Errorf("rejected cert hash %s from peer %s", certHashHex, peerID)
 			}
 			log.Printf("Peer %s cert hash verified: %s…", peerID, certHashHex[:16])
 		} else {
 			log.Printf("Peer %s presented cert hash %s… (enforcement off)", peerID, certHashHex[:16])
 		}
+		resumeCursorOffset = certHashOffset + 32
 	} else if len(cfg.AllowedCertHashes) > 0 {
 		_ = WriteFrame(conn, FrameReject, []byte("certificate hash required"))
-		return "", fmt.Errorf("peer %s did not provide cert hash (required)", peerID)
+		return "", 0, fmt.Errorf("peer %s did not provide cert hash (required)", peerID)
+	}
+
+	// --- Step 1c: Resume cursor (last store-and-forward sequence the
+	// phone has already seen), optional for backward compatibility ---
+	var resumeCursor uint64
+	if resumeCursorOffset+8 <= len(hello.Payload) {
+		resumeCursor = binary.BigEndian.Uint64(hello.Payload[resumeCursorOffset : resumeCursorOffset+8])
 	}
 
 	// --- Step 2: Send CHALLENGE ---
+	admission.RecordAttempt()
+	difficulty := admission.Difficulty(conn.RemoteAddr(), clientCount)
+
 	nonce, err := GenerateChallenge()
 	if err != nil {
-		return "", fmt.Errorf("generate challenge: %w", err)
+		return "", 0, fmt.Errorf("generate challenge: %w", err)
 	}
 	challenge := make([]byte, 33)
 	copy(challenge[:32], nonce[:])
-	challenge[32] = cfg.PoWDifficulty
+	challenge[32] = difficulty
 
 	if err := WriteFrame(conn, FrameChallenge, challenge); err != nil {
-		return "", fmt.Errorf("write CHALLENGE: %w", err)
+		return "", 0, fmt.Errorf("write CHALLENGE: %w", err)
 	}
 
 	// --- Step 3: Read SOLUTION ---
 	sol, err := ReadFrame(conn, cfg.MaxPacketSize)
 	if err != nil {
-		return "", fmt.Errorf("read SOLUTION: %w", err)
+		return "", 0, fmt.Errorf("read SOLUTION: %w", err)
 	}
 	if sol.Type != FrameSolution {
-		return "", fmt.Errorf("expected SOLUTION (0x%02x), got 0x%02x", FrameSolution, sol.Type)
+		return "", 0, fmt.Errorf("expected SOLUTION (0x%02x), got 0x%02x", FrameSolution, sol.Type)
 	}
 	if len(sol.Payload) != 8 {
-		return "", fmt.Errorf("SOLUTION wrong size: %d (expected 8)", len(sol.Payload))
+		return "", 0, fmt.Errorf("SOLUTION wrong size: %d (expected 8)", len(sol.Payload))
 	}
 
 	solution := binary.BigEndian.Uint64(sol.Payload)
 
 	// --- Step 4: Verify PoW ---
-	if !VerifyPoW(nonce, solution, cfg.PoWDifficulty) {
+	if !VerifyPoW(nonce, solution, difficulty) {
+		admission.RecordFailure(conn.RemoteAddr())
 		_ = WriteFrame(conn, FrameReject, []byte("invalid proof of work"))
-		return "", fmt.Errorf("invalid PoW from peer %s", peerID)
+		return "", 0, fmt.Errorf("invalid PoW from peer %s", peerID)
 	}
 
 	// --- Step 5: Accept ---
 	if err := WriteFrame(conn, FrameAccept, nil); err != nil {
-		return "", fmt.Errorf("write ACCEPT: %w", err)
+		return "", 0, fmt.Errorf("write ACCEPT: %w", err)
 	}
 
-	return peerID, nil
+	return peerID, resumeCursor, nil
 }
@@ -17,14 +17,35 @@ func main() {
 	flag.StringVar(&cfg.MeshInterface, "mesh-iface", cfg.MeshInterface, "batman-adv network interface")
 	flag.StringVar(&cfg.MeshMulticast, "mesh-group", cfg.MeshMulticast, "multicast group address")
 	flag.StringVar(&cfg.CertDir, "cert-dir", cfg.CertDir, "TLS certificate directory")
+	flag.StringVar(&cfg.ACMEDomain, "acme-domain", cfg.ACMEDomain, "public DNS name to request a Let's Encrypt certificate for; empty uses a self-signed cert from cert-dir")
+	flag.StringVar(&cfg.ACMEEmail, "acme-email", cfg.ACMEEmail, "contact email registered with the ACME CA")
+	flag.StringVar(&cfg.ACMECache, "acme-cache", cfg.ACMECache, "directory autocert persists issued certificates to")
+	flag.DurationVar(&cfg.TLSCertReloadInterval, "tls-cert-reload-interval", cfg.TLSCertReloadInterval, "how often the self-signed TLS listener re-reads relay.crt/relay.key; 0 disables reloading")
+	flag.DurationVar(&cfg.CertReloadInterval, "cert-reload-interval", cfg.CertReloadInterval, "how often relay.cert is re-read from disk to pick up a reissued certificate; 0 disables reloading")
+	flag.StringVar(&cfg.MeshTransport, "mesh-transport", cfg.MeshTransport, "mesh backend: multicast, quic, or libp2p")
+	flag.StringVar(&cfg.MeshQUICListen, "mesh-quic-listen", cfg.MeshQUICListen, "listen address for the quic mesh transport")
+	quicPeers := flag.String("mesh-quic-peers", "", "comma-separated seed addresses to dial for the quic mesh transport")
+	flag.StringVar(&cfg.MeshLibp2pListen, "mesh-libp2p-listen", cfg.MeshLibp2pListen, "multiaddr to listen on for the libp2p mesh transport")
+	libp2pPeers := flag.String("mesh-libp2p-peers", "", "comma-separated seed peer multiaddrs for the libp2p mesh transport")
+	flag.StringVar(&cfg.MeshLibp2pTopic, "mesh-libp2p-topic", cfg.MeshLibp2pTopic, "gossipsub topic for the libp2p mesh transport")
 	flag.IntVar(&cfg.MaxClients, "max-clients", cfg.MaxClients, "maximum simultaneous phone connections")
-	difficulty := flag.Int("pow-difficulty", int(cfg.PoWDifficulty), "proof-of-work difficulty (leading zero bits)")
+	difficulty := flag.Int("pow-difficulty", int(cfg.PoWDifficulty), "baseline proof-of-work difficulty (leading zero bits)")
+	maxDifficulty := flag.Int("pow-max-difficulty", int(cfg.PoWMaxDifficulty), "maximum adaptive proof-of-work difficulty")
+	flag.Float64Var(&cfg.PoWSoftThreshold, "pow-soft-threshold", cfg.PoWSoftThreshold, "handshake attempts/sec above which difficulty rises; 0 disables")
 	certHashes := flag.String("allowed-cert-hash", "", "comma-separated APK cert SHA-256 hashes (hex); empty = open")
 	keyDir := flag.String("key-dir", cfg.KeyDir, "directory for relay Ed25519 signing key")
 	caPubKey := flag.String("ca-pubkey", cfg.CAPubKey, "CA public key (hex) for relay certificate verification; empty = open")
 	crlPath := flag.String("crl-path", cfg.CRLPath, "path to certificate revocation list file")
+	flag.BoolVar(&cfg.CRLLegacy, "crl-legacy", cfg.CRLLegacy, "read crl-path as a plain newline-delimited hex-key list with no signature, instead of the signed binary CRL format; for open (non-CA) deployments")
+	flag.StringVar(&cfg.CertRenewURL, "cert-renew-url", cfg.CertRenewURL, "HTTPS endpoint to renew the relay's short-lived CA certificate from; empty disables renewal")
+	flag.DurationVar(&cfg.MaxAttestationAge, "max-attestation-age", cfg.MaxAttestationAge, "reject mesh envelopes whose stapled timestamp is older than this; 0 disables the check")
+	flag.IntVar(&cfg.SendQueueDepth, "send-queue-depth", cfg.SendQueueDepth, "per-client DATA send queue depth (head-drop on overflow)")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "loopback address for /debug/vars metrics endpoint; empty disables it")
+	flag.DurationVar(&cfg.BufferTTL, "buffer-ttl", cfg.BufferTTL, "max age of a store-and-forward packet eligible for replay; 0 disables expiry")
+	flag.StringVar(&cfg.BufferDBPath, "buffer-db-path", cfg.BufferDBPath, "bbolt database path to persist the store-and-forward buffer across restarts; empty keeps it in-memory only")
 	flag.Parse()
 	cfg.PoWDifficulty = uint8(*difficulty)
+	cfg.PoWMaxDifficulty = uint8(*maxDifficulty)
 	cfg.KeyDir = *keyDir
 	cfg.CAPubKey = *caPubKey
 	cfg.CRLPath = *crlPath
@@ -38,17 +59,27 @@ func main() {
 			}
 		}
 	}
+	cfg.MeshQUICPeers = splitNonEmpty(*quicPeers)
+	cfg.MeshLibp2pPeers = splitNonEmpty(*libp2pPeers)
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Printf("BitChat Relay Daemon starting")
 	log.Printf("  TLS port:       %d", cfg.TLSPort)
-	log.Printf("  Mesh port:      %d (multicast %s)", cfg.MeshPort, cfg.MeshMulticast)
-	log.Printf("  Mesh interface: %s", cfg.MeshInterface)
+	log.Printf("  Mesh transport: %s", cfg.MeshTransport)
+	if cfg.MeshTransport == "" || cfg.MeshTransport == "multicast" {
+		log.Printf("  Mesh port:      %d (multicast %s)", cfg.MeshPort, cfg.MeshMulticast)
+		log.Printf("  Mesh interface: %s", cfg.MeshInterface)
+	}
+	if cfg.ACMEDomain != "" {
+		log.Printf("  TLS cert:       ACME (%s, cache %s)", cfg.ACMEDomain, cfg.ACMECache)
+	} else {
+		log.Printf("  TLS cert:       self-signed (%s)", cfg.CertDir)
+	}
 	log.Printf("  Max clients:    %d", cfg.MaxClients)
-	log.Printf("  PoW difficulty: %d bits", cfg.PoWDifficulty)
+	log.Printf("  PoW difficulty: %d-%d bits (adaptive above %.1f attempts/sec)", cfg.PoWDifficulty, cfg.PoWMaxDifficulty, cfg.PoWSoftThreshold)
 	log.Printf("  Cert directory: %s", cfg.CertDir)
 
-	relayAuth, err := NewRelayAuth(cfg.KeyDir, cfg.CAPubKey, cfg.CRLPath)
+	relayAuth, err := NewRelayAuth(cfg.KeyDir, cfg.CAPubKey, cfg.CRLPath, cfg.CertRenewURL, cfg.CertReloadInterval, cfg.CRLLegacy)
 	if err != nil {
 		log.Fatalf("FATAL: relay auth init failed: %v", err)
 	}
@@ -57,6 +88,8 @@ func main() {
 		log.Printf("  CA mode:        ENABLED")
 		if relayAuth.HasCertificate() {
 			log.Printf("  Relay cert:     loaded")
+		} else if cfg.CertRenewURL != "" {
+			log.Printf("  Relay cert:     pending (renewing from %s)", cfg.CertRenewURL)
 		} else {
 			log.Printf("  Relay cert:     MISSING (run mesh-ca sign to issue one)")
 		}
@@ -69,7 +102,20 @@ func main() {
 		log.Printf("  APK hashes:     open (any app accepted)")
 	}
 
-	router := NewRouter(cfg)
+	router, err := NewRouter(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: router init failed: %v", err)
+	}
+	defer router.Close()
+
+	if cfg.MetricsAddr != "" {
+		metricsServer, err := StartMetricsServer(cfg.MetricsAddr, router)
+		if err != nil {
+			log.Printf("WARNING: metrics server unavailable: %v", err)
+		} else {
+			defer metricsServer.Close()
+		}
+	}
 
 	mesh, err := NewMeshLink(cfg)
 	if err != nil {
@@ -79,12 +125,12 @@ func main() {
 		mesh.SetRouter(router)
 		mesh.SetAuth(relayAuth)
 		router.SetMesh(mesh)
-		go mesh.RecvLoop()
+		mesh.Start()
 		defer mesh.Close()
 		if relayAuth.HasCA() {
-			log.Printf("Mesh link active on %s (%s:%d) [CA-verified Ed25519]", cfg.MeshInterface, cfg.MeshMulticast, cfg.MeshPort)
+			log.Printf("Mesh link active via %s transport [CA-verified Ed25519]", cfg.MeshTransport)
 		} else {
-			log.Printf("Mesh link active on %s (%s:%d) [Ed25519 signed, open]", cfg.MeshInterface, cfg.MeshMulticast, cfg.MeshPort)
+			log.Printf("Mesh link active via %s transport [Ed25519 signed, open]", cfg.MeshTransport)
 		}
 	}
 
@@ -100,3 +146,19 @@ func main() {
 	sig := <-sigCh
 	log.Printf("Received %s â€” shutting down", sig)
 }
+
+// splitNonEmpty splits a comma-separated flag value, trimming
+// whitespace and dropping empty entries.
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
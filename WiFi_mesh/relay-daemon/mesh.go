@@ -1,196 +1,372 @@
 package main
 
 import (
-	"fmt"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
 	"log"
-	"net"
+	"time"
 )
 
 // Mesh packet header sizes
 const (
 	pubKeyLen = 32
-	certLen   = 64
-	sigLen    = 64
-	// CA mode: [32 pubkey][64 cert][64 sig][payload] = 160 byte header
-	caHeaderLen = pubKeyLen + certLen + sigLen
+	serialLen = 8
+	// certLen is the short-lived certificate format:
+	// [serial:8][notBefore:8][notAfter:8][ca-sig:64], the signature
+	// covering serial||notBefore||notAfter||relayPub. The pubkey itself
+	// isn't repeated inside the cert — it's already carried separately
+	// in the envelope header. See RelayAuth.VerifyCertificate. The
+	// serial (rather than the pubkey) is what the CRL revokes, so a
+	// relay that legitimately rotates its key isn't permanently banned
+	// by an old revocation.
+	certLen      = serialLen + 2*timestampLen + sigLen
+	sigLen       = 64
+	timestampLen = 8
+
+	// CA mode: [32 pubkey][88 cert][8 timestamp][64 sig][payload]
+	caHeaderLen = pubKeyLen + certLen + timestampLen + sigLen
+	// Legacy (no CA) mode: [32 pubkey][8 timestamp][64 sig][payload]
+	legacyHeaderLen = pubKeyLen + timestampLen + sigLen
+)
+
+// The signature in both header modes covers timestamp||inner rather
+// than just inner, so the timestamp is itself authenticated and can't
+// be stripped or backdated by a relay forwarding someone else's
+// envelope. RecvLoop rejects envelopes whose timestamp is older than
+// cfg.MaxAttestationAge, which bounds how long a captured envelope can
+// be replayed. The sender stamps the timestamp itself, so this check
+// does not cut off a revoked relay; in CA mode that comes from the
+// certificate's notAfter, checked on every envelope, once the CA stops
+// renewing it (see auth.go's RenewLoop).
+
+// Top-level mesh message types, carried by whichever MeshTransport is
+// configured.
+const (
+	// meshMsgSigned is the CA/legacy Ed25519-signed envelope. It
+	// carries presence beacons and Noise-IK handshake messages — never
+	// application DATA — since an established Noise session
+	// authenticates and encrypts DATA on its own.
+	meshMsgSigned byte = 0x01
+	// meshMsgData is a Noise-session-encrypted application packet:
+	// [peer-index:4][counter:8][ciphertext||tag]. It is broadcast like
+	// everything else; only the peer holding the matching session
+	// index can decrypt it, so addressing happens logically rather
+	// than at the transport level.
+	meshMsgData byte = 0x02
+)
+
+// Inner payload types carried inside a meshMsgSigned envelope.
+const (
+	noiseInnerPresence byte = 0x00
+	noiseInnerInit     byte = 0x01
+	noiseInnerResp     byte = 0x02
 )
 
-// MeshLink handles UDP multicast communication with other relay daemons
-// over the batman-adv interface. Outgoing packets are signed with Ed25519
-// and include the relay's CA certificate. Incoming packets are verified
-// against the CA and checked for revocation.
+const handshakeMaintenanceInterval = 10 * time.Second
+
+// MeshLink handles communication with other relay daemons. It owns
+// signing, deduplication of stale envelopes, and the Noise-IK session
+// layer; the actual delivery of bytes between relays is delegated to a
+// pluggable MeshTransport (batman-adv multicast, QUIC, or libp2p
+// gossipsub — see transport.go), so none of that logic changes when
+// the backend does.
 type MeshLink struct {
-	sendConn *net.UDPConn
-	recvConn *net.UDPConn
-	cfg      *Config
-	router   *Router
-	auth     *RelayAuth
+	transport MeshTransport
+	cfg       *Config
+	router    *Router
+	auth      *RelayAuth
 }
 
 func NewMeshLink(cfg *Config) (*MeshLink, error) {
-	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", cfg.MeshMulticast, cfg.MeshPort))
+	transport, err := NewMeshTransport(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("resolve multicast: %w", err)
+		return nil, err
 	}
+	return &MeshLink{transport: transport, cfg: cfg}, nil
+}
 
-	iface, err := net.InterfaceByName(cfg.MeshInterface)
-	if err != nil {
-		return nil, fmt.Errorf("interface %s: %w", cfg.MeshInterface, err)
-	}
+func (m *MeshLink) SetRouter(r *Router)  { m.router = r }
+func (m *MeshLink) SetAuth(a *RelayAuth) { m.auth = a }
+
+// Start launches the background goroutines that keep the mesh link
+// running: a receive loop, a presence beacon so peers learn our
+// identity, and handshake maintenance so every known peer gets (and
+// keeps) an established Noise session.
+func (m *MeshLink) Start() {
+	go m.RecvLoop()
+	go m.presenceLoop()
+	go m.handshakeLoop()
+}
 
-	addrs, err := iface.Addrs()
-	if err != nil || len(addrs) == 0 {
-		return nil, fmt.Errorf("no addresses on %s: %w", cfg.MeshInterface, err)
+// Send encrypts data under each peer's established Noise session and
+// broadcasts it over the transport. Peers without a session yet are
+// skipped — the handshake maintenance loop establishes sessions
+// opportunistically. Packets sent before a peer's session completes
+// are not delivered to that peer later.
+func (m *MeshLink) Send(data []byte) {
+	if m.auth == nil {
+		return
 	}
-	var localIP net.IP
-	for _, a := range addrs {
-		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
-			localIP = ipnet.IP
-			break
+	for _, peerHex := range m.auth.KnownPeers() {
+		sess, ok := m.auth.ActiveSession(peerHex)
+		if !ok {
+			continue
 		}
-	}
-	if localIP == nil {
-		return nil, fmt.Errorf("no IPv4 address on %s", cfg.MeshInterface)
-	}
 
-	sendConn, err := net.DialUDP("udp4", &net.UDPAddr{IP: localIP}, addr)
-	if err != nil {
-		return nil, fmt.Errorf("dial multicast: %w", err)
-	}
+		ciphertext, counter, err := sess.encrypt(data)
+		if err != nil {
+			log.Printf("mesh send: encrypt for %s…: %v", peerHex[:8], err)
+			continue
+		}
 
-	recvConn, err := net.ListenMulticastUDP("udp4", iface, addr)
-	if err != nil {
-		sendConn.Close()
-		return nil, fmt.Errorf("listen multicast: %w", err)
-	}
-	recvConn.SetReadBuffer(1 << 20)
+		msg := make([]byte, 1+4+8+len(ciphertext))
+		msg[0] = meshMsgData
+		binary.BigEndian.PutUint32(msg[1:5], sess.peerIndex)
+		binary.BigEndian.PutUint64(msg[5:13], counter)
+		copy(msg[13:], ciphertext)
 
-	return &MeshLink{
-		sendConn: sendConn,
-		recvConn: recvConn,
-		cfg:      cfg,
-	}, nil
+		if err := m.transport.Send(msg); err != nil {
+			log.Printf("mesh send: %v", err)
+		}
+	}
 }
 
-func (m *MeshLink) SetRouter(r *Router) { m.router = r }
-func (m *MeshLink) SetAuth(a *RelayAuth) { m.auth = a }
-
-// Send transmits data via UDP multicast with Ed25519 signature.
-// CA mode: [32 pubkey][64 cert][64 sig][payload]
-// Legacy (no CA): [32 pubkey][64 sig][payload]
-func (m *MeshLink) Send(data []byte) {
+// sendSignedBroadcast wraps an inner handshake/presence payload in the
+// CA/legacy Ed25519 envelope, stapling a fresh timestamp that the
+// receiver checks against cfg.MaxAttestationAge, and broadcasts it
+// over the transport.
+func (m *MeshLink) sendSignedBroadcast(innerType byte, innerPayload []byte) {
 	if m.auth == nil {
 		return
 	}
+	inner := make([]byte, 1+len(innerPayload))
+	inner[0] = innerType
+	copy(inner[1:], innerPayload)
 
-	sig := m.auth.Sign(data)
+	var ts [timestampLen]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().Unix()))
 
-	if m.auth.HasCA() && m.auth.HasCertificate() {
-		msg := make([]byte, caHeaderLen+len(data))
-		copy(msg[:pubKeyLen], m.auth.PublicKey)
-		copy(msg[pubKeyLen:pubKeyLen+certLen], m.auth.Certificate)
-		copy(msg[pubKeyLen+certLen:caHeaderLen], sig)
-		copy(msg[caHeaderLen:], data)
-		if _, err := m.sendConn.Write(msg); err != nil {
-			log.Printf("mesh send: %v", err)
-		} else {
-			log.Printf("mesh send: %d bytes (CA-signed)", len(data))
-		}
+	signed := make([]byte, timestampLen+len(inner))
+	copy(signed, ts[:])
+	copy(signed[timestampLen:], inner)
+	sig := m.auth.Sign(signed)
+
+	var msg []byte
+	if cert := m.auth.CurrentCertificate(); m.auth.HasCA() && len(cert) == certLen {
+		msg = make([]byte, 1+caHeaderLen+len(inner))
+		msg[0] = meshMsgSigned
+		copy(msg[1:1+pubKeyLen], m.auth.PublicKey)
+		copy(msg[1+pubKeyLen:1+pubKeyLen+certLen], cert)
+		copy(msg[1+pubKeyLen+certLen:1+pubKeyLen+certLen+timestampLen], ts[:])
+		copy(msg[1+pubKeyLen+certLen+timestampLen:1+caHeaderLen], sig)
+		copy(msg[1+caHeaderLen:], inner)
 	} else {
-		legacyHeaderLen := pubKeyLen + sigLen
-		msg := make([]byte, legacyHeaderLen+len(data))
-		copy(msg[:pubKeyLen], m.auth.PublicKey)
-		copy(msg[pubKeyLen:legacyHeaderLen], sig)
-		copy(msg[legacyHeaderLen:], data)
-		if _, err := m.sendConn.Write(msg); err != nil {
-			log.Printf("mesh send: %v", err)
-		} else {
-			log.Printf("mesh send: %d bytes (legacy-signed)", len(data))
+		msg = make([]byte, 1+legacyHeaderLen+len(inner))
+		msg[0] = meshMsgSigned
+		copy(msg[1:1+pubKeyLen], m.auth.PublicKey)
+		copy(msg[1+pubKeyLen:1+pubKeyLen+timestampLen], ts[:])
+		copy(msg[1+pubKeyLen+timestampLen:1+legacyHeaderLen], sig)
+		copy(msg[1+legacyHeaderLen:], inner)
+	}
+
+	if err := m.transport.Send(msg); err != nil {
+		log.Printf("mesh send: %v", err)
+	}
+}
+
+// presenceLoop periodically broadcasts a signed, empty presence beacon
+// so peers learn our identity even when we have no data to send.
+func (m *MeshLink) presenceLoop() {
+	ticker := time.NewTicker(m.cfg.KeepaliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.sendSignedBroadcast(noiseInnerPresence, nil)
+	}
+}
+
+// handshakeLoop establishes a Noise session with every known peer that
+// doesn't have one yet, and rekeys sessions that have hit their message
+// or time limit.
+func (m *MeshLink) handshakeLoop() {
+	ticker := time.NewTicker(handshakeMaintenanceInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if m.auth == nil {
+			continue
+		}
+		for _, peerHex := range m.auth.KnownPeers() {
+			if sess, ok := m.auth.ActiveSession(peerHex); ok && !sess.needsRekey() {
+				continue
+			}
+			m.initiateHandshake(peerHex)
 		}
 	}
 }
 
-// RecvLoop reads UDP multicast packets from other relay daemons.
-// CA mode expects [32 pubkey][64 cert][64 sig][payload].
-// Legacy mode expects [32 pubkey][64 sig][payload].
+func (m *MeshLink) initiateHandshake(peerHex string) {
+	pub, err := hex.DecodeString(peerHex)
+	if err != nil || len(pub) != pubKeyLen {
+		return
+	}
+	msg1, err := m.auth.BeginHandshake(peerHex, ed25519.PublicKey(pub))
+	if err != nil {
+		log.Printf("mesh: begin handshake with %s…: %v", peerHex[:8], err)
+		return
+	}
+	m.sendSignedBroadcast(noiseInnerInit, msg1)
+}
+
+// RecvLoop reads messages from the transport and dispatches them by
+// their leading type byte: a signed envelope (presence/handshake) or a
+// Noise-encrypted DATA packet.
 func (m *MeshLink) RecvLoop() {
-	buf := make([]byte, m.cfg.MaxPacketSize+caHeaderLen)
 	for {
-		n, _, err := m.recvConn.ReadFromUDP(buf)
+		data, peer, err := m.transport.Recv()
 		if err != nil {
 			log.Printf("mesh recv: %v", err)
 			continue
 		}
-
-		if m.auth == nil || n <= pubKeyLen+sigLen {
+		if len(data) < 1 {
 			continue
 		}
+		switch data[0] {
+		case meshMsgSigned:
+			m.handleSigned(data[1:], peer)
+		case meshMsgData:
+			m.handleData(data[1:])
+		default:
+			log.Printf("mesh recv: unexpected frame 0x%02x from %s", data[0], peer.Addr)
+		}
+	}
+}
+
+// handleSigned verifies a signed envelope (pubkey/cert/timestamp/sig,
+// self/revoked checks) and dispatches its inner payload to presence or
+// handshake handling. The stapled timestamp is rejected if older than
+// cfg.MaxAttestationAge; the certificate's validity window, checked
+// against the current time on every envelope, is what stops a relay
+// whose short-lived CA cert was not renewed (auth.go's RenewLoop).
+func (m *MeshLink) handleSigned(data []byte, peer PeerInfo) {
+	if m.auth == nil || len(data) <= pubKeyLen+timestampLen+sigLen {
+		return
+	}
 
-		pubKey := make([]byte, pubKeyLen)
-		copy(pubKey, buf[:pubKeyLen])
+	pubKey := make([]byte, pubKeyLen)
+	copy(pubKey, data[:pubKeyLen])
 
-		// Step 1: skip our own multicast echo
-		if m.auth.IsSelf(pubKey) {
-			continue
+	if m.auth.IsSelf(pubKey) {
+		return
+	}
+	if m.auth.IsRevoked(pubKey) {
+		log.Printf("mesh recv: REVOKED key %x…", pubKey[:8])
+		return
+	}
+
+	var ts []byte
+	var sig []byte
+	var inner []byte
+	var certSerial uint64
+	haveCertSerial := false
+	if m.auth.HasCA() {
+		if len(data) <= caHeaderLen {
+			return
 		}
+		cert := data[pubKeyLen : pubKeyLen+certLen]
+		ts = data[pubKeyLen+certLen : pubKeyLen+certLen+timestampLen]
+		sig = data[pubKeyLen+certLen+timestampLen : caHeaderLen]
+		inner = data[caHeaderLen:]
 
-		// Step 2: check revocation list
-		if m.auth.IsRevoked(pubKey) {
-			log.Printf("mesh recv: REVOKED key %x…", pubKey[:8])
-			continue
+		if !m.auth.VerifyCertificate(pubKey, cert) {
+			log.Printf("mesh recv: invalid or expired CA cert from %x…", pubKey[:8])
+			return
 		}
+		certSerial = binary.BigEndian.Uint64(cert[:serialLen])
+		haveCertSerial = true
+	} else {
+		ts = data[pubKeyLen : pubKeyLen+timestampLen]
+		sig = data[pubKeyLen+timestampLen : legacyHeaderLen]
+		inner = data[legacyHeaderLen:]
+	}
 
-		if m.auth.HasCA() {
-			// CA mode: [32 pubkey][64 cert][64 sig][payload]
-			if n <= caHeaderLen {
-				continue
-			}
-			cert := make([]byte, certLen)
-			copy(cert, buf[pubKeyLen:pubKeyLen+certLen])
-			sig := make([]byte, sigLen)
-			copy(sig, buf[pubKeyLen+certLen:caHeaderLen])
-			data := make([]byte, n-caHeaderLen)
-			copy(data, buf[caHeaderLen:n])
-
-			// Step 3: verify certificate against CA (caches on success)
-			if !m.auth.VerifyCertificate(pubKey, cert) {
-				log.Printf("mesh recv: invalid CA cert from %x…", pubKey[:8])
-				continue
-			}
+	signed := make([]byte, timestampLen+len(inner))
+	copy(signed, ts)
+	copy(signed[timestampLen:], inner)
+	if !m.auth.Verify(pubKey, sig, signed) {
+		log.Printf("mesh recv: invalid signature from %x…", pubKey[:8])
+		return
+	}
 
-			// Step 4: verify packet signature
-			if !m.auth.Verify(pubKey, sig, data) {
-				log.Printf("mesh recv: invalid signature from %x…", pubKey[:8])
-				continue
-			}
+	if m.cfg.MaxAttestationAge > 0 {
+		sentAt := time.Unix(int64(binary.BigEndian.Uint64(ts)), 0)
+		if age := time.Since(sentAt); age > m.cfg.MaxAttestationAge {
+			log.Printf("mesh recv: stale attestation from %x… (%s old)", pubKey[:8], age)
+			return
+		}
+	}
 
-			if m.router != nil {
-				m.router.RouteFromMesh(data)
-			}
-		} else {
-			// Legacy/open mode: [32 pubkey][64 sig][payload]
-			legacyHeaderLen := pubKeyLen + sigLen
-			if n <= legacyHeaderLen {
-				continue
-			}
-			sig := make([]byte, sigLen)
-			copy(sig, buf[pubKeyLen:legacyHeaderLen])
-			data := make([]byte, n-legacyHeaderLen)
-			copy(data, buf[legacyHeaderLen:n])
+	if len(inner) < 1 {
+		return
+	}
 
-			if !m.auth.Verify(pubKey, sig, data) {
-				log.Printf("mesh recv: invalid signature from %x…", pubKey[:8])
-				continue
-			}
+	pubHex := hex.EncodeToString(pubKey)
+	m.auth.RecordPeerAddr(pubHex, peer.Addr)
+	if haveCertSerial {
+		m.auth.RecordPeerCert(pubHex, certSerial)
+	}
 
-			if m.router != nil {
-				m.router.RouteFromMesh(data)
-			}
+	switch inner[0] {
+	case noiseInnerPresence:
+		// address learning above is all presence beacons are for
+
+	case noiseInnerInit:
+		msg2, err := m.auth.HandleHandshakeInit(pubHex, inner[1:])
+		if err != nil {
+			log.Printf("mesh: handshake init from %x…: %v", pubKey[:8], err)
+			return
 		}
+		m.sendSignedBroadcast(noiseInnerResp, msg2)
+
+	case noiseInnerResp:
+		if err := m.auth.HandleHandshakeResp(pubHex, inner[1:]); err != nil {
+			log.Printf("mesh: handshake resp from %x…: %v", pubKey[:8], err)
+		}
+
+	default:
+		log.Printf("mesh recv: unexpected inner type 0x%02x from %x…", inner[0], pubKey[:8])
+	}
+}
+
+// handleData decrypts a Noise-session DATA packet addressed by peer
+// index and hands the plaintext to the router. Every relay sees every
+// DATA packet (the transport broadcasts); only the one holding the
+// session behind that index can decrypt it, so the rest silently
+// ignore it.
+func (m *MeshLink) handleData(data []byte) {
+	if m.auth == nil || len(data) < 12 {
+		return
+	}
+
+	peerIndex := binary.BigEndian.Uint32(data[0:4])
+	counter := binary.BigEndian.Uint64(data[4:12])
+	ciphertext := data[12:]
+
+	sess, pubHex, ok := m.auth.SessionByIndex(peerIndex)
+	if !ok || m.auth.IsPeerRevoked(pubHex) {
+		return
+	}
+
+	plaintext, err := sess.decrypt(counter, ciphertext)
+	if err != nil {
+		log.Printf("mesh recv: decrypt failed: %v", err)
+		return
+	}
+
+	if m.router != nil {
+		m.router.RouteFromMesh(plaintext)
 	}
 }
 
 func (m *MeshLink) Close() {
-	m.sendConn.Close()
-	m.recvConn.Close()
+	m.transport.Close()
 }
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// This file implements a Noise-IK session layer between relay daemons,
+// replacing the plaintext-signed multicast payload with per-peer
+// ChaCha20-Poly1305 sessions. The CA-signed envelope (auth.go, mesh.go)
+// is kept only to authenticate presence beacons and the handshake
+// messages themselves; established sessions carry DATA with no Ed25519
+// envelope at all, since the Noise handshake already provides mutual
+// authentication and the AEAD provides per-packet integrity.
+//
+// Static keys for the handshake are derived from each relay's existing
+// Ed25519 identity key via the standard birational map between Edwards25519
+// and Curve25519, so the CA's existing signature over the Ed25519 public
+// key continues to anchor trust — no new certificate format is needed.
+
+const noiseProtocolName = "Noise_IK_25519_ChaChaPoly_SHA256"
+
+// Rekey policy: whichever limit is hit first forces a fresh handshake.
+const (
+	sessionRekeyMessages = 1 << 16
+	sessionRekeyInterval = 10 * time.Minute
+)
+
+// replayWindowWords sizes the anti-replay bitmap to a 2048-bit window
+// (32 * 64), matching WireGuard's default.
+const replayWindowWords = 32
+const replayWindowSize = replayWindowWords * 64
+
+// --- Ed25519 -> X25519 key conversion -------------------------------------
+
+// curve25519Prime is 2^255 - 19.
+var curve25519Prime, _ = new(big.Int).SetString(
+	"57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+
+// edPubToX25519 converts an Ed25519 public key to its Curve25519
+// Montgomery u-coordinate via the standard birational map
+// u = (1+y)/(1-y) mod p. This is the same conversion used by
+// libsodium's crypto_sign_ed25519_pk_to_curve25519.
+func edPubToX25519(pub ed25519.PublicKey) ([]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("noise: bad ed25519 public key size %d", len(pub))
+	}
+	yLE := make([]byte, 32)
+	copy(yLE, pub)
+	yLE[31] &= 0x7f // clear the sign-of-x bit, leaving the y-coordinate
+
+	yBE := make([]byte, 32)
+	for i := range yLE {
+		yBE[31-i] = yLE[i]
+	}
+	y := new(big.Int).SetBytes(yBE)
+
+	one := big.NewInt(1)
+	num := new(big.Int).Mod(new(big.Int).Add(one, y), curve25519Prime)
+	den := new(big.Int).Mod(new(big.Int).Sub(one, y), curve25519Prime)
+	denInv := new(big.Int).ModInverse(den, curve25519Prime)
+	if denInv == nil {
+		return nil, fmt.Errorf("noise: non-invertible ed25519 y-coordinate")
+	}
+	u := new(big.Int).Mod(new(big.Int).Mul(num, denInv), curve25519Prime)
+
+	uBE := u.FillBytes(make([]byte, 32))
+	uLE := make([]byte, 32)
+	for i := range uBE {
+		uLE[31-i] = uBE[i]
+	}
+	return uLE, nil
+}
+
+// edPrivToX25519 derives the Curve25519 scalar for an Ed25519 private
+// key: SHA-512 of the seed, clamped exactly as RFC 7748 X25519 keys are.
+// This is the same derivation Ed25519 itself uses internally, so the
+// resulting scalar multiplies the base point consistently with the
+// public-key conversion above.
+func edPrivToX25519(priv ed25519.PrivateKey) []byte {
+	h := sha512.Sum512(priv.Seed())
+	scalar := make([]byte, 32)
+	copy(scalar, h[:32])
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	return scalar
+}
+
+func newNoiseStaticKey(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().NewPrivateKey(edPrivToX25519(priv))
+}
+
+// --- Noise symmetric state -------------------------------------------------
+
+type noiseSymmetricState struct {
+	ck     [32]byte
+	h      [32]byte
+	key    [32]byte
+	hasKey bool
+	nonce  uint64
+}
+
+func newSymmetricState() *noiseSymmetricState {
+	s := &noiseSymmetricState{}
+	s.h = sha256.Sum256([]byte(noiseProtocolName))
+	s.ck = s.h
+	return s
+}
+
+func (s *noiseSymmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	copy(s.h[:], h.Sum(nil))
+}
+
+func hmacHash(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// hkdf2 is the Noise HKDF: two pseudorandom outputs derived from a
+// chaining key and input key material via nested HMAC-SHA256.
+func hkdf2(chainKey, ikm []byte) (out1, out2 [32]byte) {
+	tempKey := hmacHash(chainKey, ikm)
+	o1 := hmacHash(tempKey, []byte{0x01})
+	o2 := hmacHash(tempKey, append(append([]byte{}, o1...), 0x02))
+	copy(out1[:], o1)
+	copy(out2[:], o2)
+	return
+}
+
+func (s *noiseSymmetricState) mixKey(ikm []byte) {
+	s.ck, s.key = hkdf2(s.ck[:], ikm)
+	s.hasKey = true
+	s.nonce = 0
+}
+
+func (s *noiseSymmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	if !s.hasKey {
+		s.mixHash(plaintext)
+		return append([]byte{}, plaintext...), nil
+	}
+	aead, err := chacha20poly1305.New(s.key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], s.nonce)
+	s.nonce++
+	ciphertext := aead.Seal(nil, nonce, plaintext, s.h[:])
+	s.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (s *noiseSymmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if !s.hasKey {
+		s.mixHash(ciphertext)
+		return append([]byte{}, ciphertext...), nil
+	}
+	aead, err := chacha20poly1305.New(s.key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], s.nonce)
+	s.nonce++
+	plaintext, err := aead.Open(nil, nonce, ciphertext, s.h[:])
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// --- Noise-IK handshake state ----------------------------------------------
+
+// noiseHandshake drives one Noise_IK_25519_ChaChaPoly_SHA256 handshake:
+//
+//	-> e, es, s, ss   (initiator's message 1)
+//	<- e, ee, se      (responder's message 2)
+type noiseHandshake struct {
+	sym       *noiseSymmetricState
+	initiator bool
+	s         *ecdh.PrivateKey // our static key
+	e         *ecdh.PrivateKey // our ephemeral key for this handshake
+	rs        *ecdh.PublicKey  // remote static key
+	re        *ecdh.PublicKey  // remote ephemeral key
+}
+
+func newInitiatorHandshake(localStatic *ecdh.PrivateKey, remoteStatic *ecdh.PublicKey) *noiseHandshake {
+	hs := &noiseHandshake{sym: newSymmetricState(), initiator: true, s: localStatic, rs: remoteStatic}
+	hs.sym.mixHash(remoteStatic.Bytes()) // IK pre-message: "<- s", known out of band
+	return hs
+}
+
+func newResponderHandshake(localStatic *ecdh.PrivateKey) *noiseHandshake {
+	hs := &noiseHandshake{sym: newSymmetricState(), initiator: false, s: localStatic}
+	hs.sym.mixHash(localStatic.PublicKey().Bytes())
+	return hs
+}
+
+// writeMessage1 is called by the initiator: e, es, s, ss.
+func (hs *noiseHandshake) writeMessage1(payload []byte) ([]byte, error) {
+	ekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("noise: generate ephemeral: %w", err)
+	}
+	hs.e = ekey
+	epub := ekey.PublicKey().Bytes()
+	hs.sym.mixHash(epub)
+
+	es, err := hs.e.ECDH(hs.rs)
+	if err != nil {
+		return nil, fmt.Errorf("noise: es: %w", err)
+	}
+	hs.sym.mixKey(es)
+
+	sCipher, err := hs.sym.encryptAndHash(hs.s.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := hs.s.ECDH(hs.rs)
+	if err != nil {
+		return nil, fmt.Errorf("noise: ss: %w", err)
+	}
+	hs.sym.mixKey(ss)
+
+	payloadCipher, err := hs.sym.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, 0, len(epub)+len(sCipher)+len(payloadCipher))
+	msg = append(msg, epub...)
+	msg = append(msg, sCipher...)
+	msg = append(msg, payloadCipher...)
+	return msg, nil
+}
+
+// readMessage1 is called by the responder and returns the initiator's
+// decrypted handshake payload.
+func (hs *noiseHandshake) readMessage1(msg []byte) ([]byte, error) {
+	const sCipherLen = 32 + 16 // X25519 pubkey + Poly1305 tag
+	if len(msg) < 32+sCipherLen {
+		return nil, fmt.Errorf("noise: message1 too short")
+	}
+	reBytes := msg[:32]
+	re, err := ecdh.X25519().NewPublicKey(reBytes)
+	if err != nil {
+		return nil, fmt.Errorf("noise: bad remote ephemeral: %w", err)
+	}
+	hs.re = re
+	hs.sym.mixHash(reBytes)
+
+	es, err := hs.s.ECDH(re)
+	if err != nil {
+		return nil, fmt.Errorf("noise: es: %w", err)
+	}
+	hs.sym.mixKey(es)
+
+	sCipher := msg[32 : 32+sCipherLen]
+	sPlain, err := hs.sym.decryptAndHash(sCipher)
+	if err != nil {
+		return nil, fmt.Errorf("noise: decrypt remote static: %w", err)
+	}
+	rs, err := ecdh.X25519().NewPublicKey(sPlain)
+	if err != nil {
+		return nil, fmt.Errorf("noise: bad remote static: %w", err)
+	}
+	hs.rs = rs
+
+	ss, err := hs.s.ECDH(rs)
+	if err != nil {
+		return nil, fmt.Errorf("noise: ss: %w", err)
+	}
+	hs.sym.mixKey(ss)
+
+	plain, err := hs.sym.decryptAndHash(msg[32+sCipherLen:])
+	if err != nil {
+		return nil, fmt.Errorf("noise: decrypt payload: %w", err)
+	}
+	return plain, nil
+}
+
+// writeMessage2 is called by the responder: e, ee, se.
+func (hs *noiseHandshake) writeMessage2(payload []byte) ([]byte, error) {
+	ekey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("noise: generate ephemeral: %w", err)
+	}
+	hs.e = ekey
+	epub := ekey.PublicKey().Bytes()
+	hs.sym.mixHash(epub)
+
+	ee, err := hs.e.ECDH(hs.re)
+	if err != nil {
+		return nil, fmt.Errorf("noise: ee: %w", err)
+	}
+	hs.sym.mixKey(ee)
+
+	se, err := hs.e.ECDH(hs.rs)
+	if err != nil {
+		return nil, fmt.Errorf("noise: se: %w", err)
+	}
+	hs.sym.mixKey(se)
+
+	payloadCipher, err := hs.sym.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, 0, len(epub)+len(payloadCipher))
+	msg = append(msg, epub...)
+	msg = append(msg, payloadCipher...)
+	return msg, nil
+}
+
+// readMessage2 is called by the initiator and returns the responder's
+// decrypted handshake payload.
+func (hs *noiseHandshake) readMessage2(msg []byte) ([]byte, error) {
+	if len(msg) < 32+16 {
+		return nil, fmt.Errorf("noise: message2 too short")
+	}
+	reBytes := msg[:32]
+	re, err := ecdh.X25519().NewPublicKey(reBytes)
+	if err != nil {
+		return nil, fmt.Errorf("noise: bad remote ephemeral: %w", err)
+	}
+	hs.re = re
+	hs.sym.mixHash(reBytes)
+
+	ee, err := hs.e.ECDH(re)
+	if err != nil {
+		return nil, fmt.Errorf("noise: ee: %w", err)
+	}
+	hs.sym.mixKey(ee)
+
+	se, err := hs.s.ECDH(re)
+	if err != nil {
+		return nil, fmt.Errorf("noise: se: %w", err)
+	}
+	hs.sym.mixKey(se)
+
+	return hs.sym.decryptAndHash(msg[32:])
+}
+
+// split derives the final per-direction transport keys once the
+// handshake completes.
+func (hs *noiseHandshake) split() (sendKey, recvKey [32]byte) {
+	k1, k2 := hkdf2(hs.sym.ck[:], nil)
+	if hs.initiator {
+		return k1, k2
+	}
+	return k2, k1
+}
+
+// --- Established session ---------------------------------------------------
+
+// meshSession holds the transport keys and anti-replay state for one
+// established Noise session with a peer.
+type meshSession struct {
+	peerIndex   uint32 // index to stamp on outgoing packets to this peer
+	sendKey     [32]byte
+	recvKey     [32]byte
+	sendCounter uint64 // atomic
+	msgCount    uint64 // atomic
+	established time.Time
+	replay      *replayFilter
+}
+
+func (s *meshSession) needsRekey() bool {
+	if time.Since(s.established) > sessionRekeyInterval {
+		return true
+	}
+	return atomic.LoadUint64(&s.msgCount) > sessionRekeyMessages
+}
+
+func (s *meshSession) encrypt(plaintext []byte) (ciphertext []byte, counter uint64, err error) {
+	aead, err := chacha20poly1305.New(s.sendKey[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	counter = atomic.AddUint64(&s.sendCounter, 1) - 1
+	atomic.AddUint64(&s.msgCount, 1)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return aead.Seal(nil, nonce, plaintext, nil), counter, nil
+}
+
+func (s *meshSession) decrypt(counter uint64, ciphertext []byte) ([]byte, error) {
+	if !s.replay.checkAndUpdate(counter) {
+		return nil, fmt.Errorf("replayed or too-old counter %d", counter)
+	}
+	aead, err := chacha20poly1305.New(s.recvKey[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// --- Sliding-window anti-replay filter --------------------------------------
+
+// replayFilter is a fixed-memory sliding-window anti-replay filter sized
+// to a 2048-bit window, the same approach WireGuard uses: a monotonic
+// high-water-mark counter plus a bitmap of recently-seen counters, with
+// stale blocks cleared lazily as the window advances instead of shifting
+// bit-by-bit. One of the 32 blocks always holds the current high-water
+// block, so a counter is accepted only if it is at most
+// 1984 + (last mod 64) behind last.
+type replayFilter struct {
+	mu     sync.Mutex
+	inited bool
+	last   uint64
+	bitmap [replayWindowWords]uint64
+}
+
+func (f *replayFilter) checkAndUpdate(counter uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.inited {
+		f.inited = true
+		f.last = counter
+		f.setBit(counter)
+		return true
+	}
+
+	if counter > f.last {
+		oldBlock := f.last / 64
+		newBlock := counter / 64
+		diff := newBlock - oldBlock
+		if diff > replayWindowWords {
+			diff = replayWindowWords
+		}
+		for i := uint64(1); i <= diff; i++ {
+			f.bitmap[(oldBlock+i)%replayWindowWords] = 0
+		}
+		f.last = counter
+		f.setBit(counter)
+		return true
+	}
+
+	// A counter whose block shares a ring slot with the current block
+	// would read bits that now belong to the current block.
+	if f.last-counter >= replayWindowSize || f.last/64-counter/64 >= replayWindowWords {
+		return false // older than the window
+	}
+	block := (counter / 64) % replayWindowWords
+	bit := uint64(1) << (counter % 64)
+	if f.bitmap[block]&bit != 0 {
+		return false // replay
+	}
+	f.bitmap[block] |= bit
+	return true
+}
+
+func (f *replayFilter) setBit(counter uint64) {
+	block := (counter / 64) % replayWindowWords
+	f.bitmap[block] |= uint64(1) << (counter % 64)
+}
+
+// --- Handshake orchestration on RelayAuth -----------------------------------
+
+// BeginHandshake starts a Noise-IK handshake as initiator with a peer
+// whose CA-authenticated Ed25519 public key is remoteEd, returning the
+// wire bytes of handshake message 1.
+func (a *RelayAuth) BeginHandshake(pubKeyHex string, remoteEd ed25519.PublicKey) ([]byte, error) {
+	if a.xPriv == nil {
+		return nil, fmt.Errorf("noise: local static key unavailable")
+	}
+	remoteX, err := edPubToX25519(remoteEd)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ecdh.X25519().NewPublicKey(remoteX)
+	if err != nil {
+		return nil, err
+	}
+
+	p := a.ensurePeer(pubKeyHex)
+	p.mu.Lock()
+	prevIdx := p.localIndex
+	p.mu.Unlock()
+
+	localIdx := a.allocateIndex(pubKeyHex, prevIdx)
+	idxPayload := make([]byte, 4)
+	binary.BigEndian.PutUint32(idxPayload, localIdx)
+
+	hs := newInitiatorHandshake(a.xPriv, rs)
+	msg1, err := hs.writeMessage1(idxPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.state = sessionHandshaking
+	p.hs = hs
+	p.localIndex = localIdx
+	p.mu.Unlock()
+
+	return msg1, nil
+}
+
+// HandleHandshakeInit processes an incoming Noise-IK message 1 and
+// returns the wire bytes of our message 2 response. On success a fully
+// established session is installed immediately (IK completes in one
+// round trip for the responder).
+func (a *RelayAuth) HandleHandshakeInit(pubKeyHex string, msg []byte) ([]byte, error) {
+	if a.xPriv == nil {
+		return nil, fmt.Errorf("noise: local static key unavailable")
+	}
+	hs := newResponderHandshake(a.xPriv)
+	initiatorPayload, err := hs.readMessage1(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(initiatorPayload) != 4 {
+		return nil, fmt.Errorf("noise: bad handshake payload length %d", len(initiatorPayload))
+	}
+	remoteIdx := binary.BigEndian.Uint32(initiatorPayload)
+
+	p := a.ensurePeer(pubKeyHex)
+	p.mu.Lock()
+	prevIdx := p.localIndex
+	p.mu.Unlock()
+
+	localIdx := a.allocateIndex(pubKeyHex, prevIdx)
+	idxPayload := make([]byte, 4)
+	binary.BigEndian.PutUint32(idxPayload, localIdx)
+
+	msg2, err := hs.writeMessage2(idxPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	sendKey, recvKey := hs.split()
+	sess := &meshSession{
+		peerIndex:   remoteIdx,
+		sendKey:     sendKey,
+		recvKey:     recvKey,
+		established: time.Now(),
+		replay:      &replayFilter{},
+	}
+
+	p.mu.Lock()
+	p.state = sessionEstablished
+	p.hs = nil
+	p.localIndex = localIdx
+	p.remoteIndex = remoteIdx
+	p.session = sess
+	p.mu.Unlock()
+
+	return msg2, nil
+}
+
+// HandleHandshakeResp completes a handshake we initiated.
+func (a *RelayAuth) HandleHandshakeResp(pubKeyHex string, msg []byte) error {
+	p := a.ensurePeer(pubKeyHex)
+	p.mu.Lock()
+	hs := p.hs
+	if hs == nil || p.state != sessionHandshaking {
+		p.mu.Unlock()
+		return fmt.Errorf("noise: no pending handshake with %s", pubKeyHex)
+	}
+	p.mu.Unlock()
+
+	responderPayload, err := hs.readMessage2(msg)
+	if err != nil {
+		return err
+	}
+	if len(responderPayload) != 4 {
+		return fmt.Errorf("noise: bad handshake payload length %d", len(responderPayload))
+	}
+	remoteIdx := binary.BigEndian.Uint32(responderPayload)
+
+	sendKey, recvKey := hs.split()
+	sess := &meshSession{
+		peerIndex:   remoteIdx,
+		sendKey:     sendKey,
+		recvKey:     recvKey,
+		established: time.Now(),
+		replay:      &replayFilter{},
+	}
+
+	p.mu.Lock()
+	p.state = sessionEstablished
+	p.hs = nil
+	p.remoteIndex = remoteIdx
+	p.session = sess
+	p.mu.Unlock()
+	return nil
+}
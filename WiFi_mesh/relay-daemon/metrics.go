@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ClientMetrics holds the expvar-style counters tracked per client:
+// frames written to the phone, and frames dropped by the send queue
+// under backpressure (either rejected outright or head-dropped to make
+// room for newer gossip).
+type ClientMetrics struct {
+	Sent             uint64
+	DroppedQueueFull uint64
+	DroppedHead      uint64
+}
+
+func (m *ClientMetrics) incSent()             { atomic.AddUint64(&m.Sent, 1) }
+func (m *ClientMetrics) incDroppedQueueFull() { atomic.AddUint64(&m.DroppedQueueFull, 1) }
+func (m *ClientMetrics) incDroppedHead()      { atomic.AddUint64(&m.DroppedHead, 1) }
+
+func (m *ClientMetrics) snapshot() ClientMetrics {
+	return ClientMetrics{
+		Sent:             atomic.LoadUint64(&m.Sent),
+		DroppedQueueFull: atomic.LoadUint64(&m.DroppedQueueFull),
+		DroppedHead:      atomic.LoadUint64(&m.DroppedHead),
+	}
+}
+
+// RouterMetrics is the process-wide sum of ClientMetrics across every
+// client that has ever connected (it survives individual disconnects,
+// unlike summing the live client set).
+type RouterMetrics struct {
+	ClientMetrics
+}
+
+// clientVars is the JSON shape published for one client on /debug/vars.
+type clientVars struct {
+	Addr             string `json:"addr"`
+	PeerID           string `json:"peer_id"`
+	Sent             uint64 `json:"sent"`
+	DroppedQueueFull uint64 `json:"dropped_queue_full"`
+	DroppedHead      uint64 `json:"dropped_head"`
+	QueueDepth       int    `json:"queue_depth"`
+}
+
+// debugVars is the full JSON document served at /debug/vars.
+type debugVars struct {
+	Global struct {
+		Sent             uint64 `json:"sent"`
+		DroppedQueueFull uint64 `json:"dropped_queue_full"`
+		DroppedHead      uint64 `json:"dropped_head"`
+		QueueDepth       int    `json:"queue_depth"`
+		Difficulty       uint8  `json:"difficulty"`
+	} `json:"global"`
+	Clients []clientVars `json:"clients"`
+}
+
+// ServeDebugVars handles GET /debug/vars, reporting global and
+// per-client send-queue counters in expvar-style JSON.
+func (r *Router) ServeDebugVars(w http.ResponseWriter, req *http.Request) {
+	var out debugVars
+
+	global := r.metrics.snapshot()
+	out.Global.Sent = global.Sent
+	out.Global.DroppedQueueFull = global.DroppedQueueFull
+	out.Global.DroppedHead = global.DroppedHead
+
+	r.mu.RLock()
+	clientCount := len(r.clients)
+	out.Clients = make([]clientVars, 0, clientCount)
+	for c := range r.clients {
+		cm := c.metrics.snapshot()
+		depth := c.QueueDepth()
+		out.Global.QueueDepth += depth
+		out.Clients = append(out.Clients, clientVars{
+			Addr:             c.addr,
+			PeerID:           c.peerID,
+			Sent:             cm.Sent,
+			DroppedQueueFull: cm.DroppedQueueFull,
+			DroppedHead:      cm.DroppedHead,
+			QueueDepth:       depth,
+		})
+	}
+	r.mu.RUnlock()
+
+	if r.Admission != nil {
+		out.Global.Difficulty = r.Admission.CurrentBaseline(clientCount)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("debug/vars encode: %v", err)
+	}
+}
+
+// StartMetricsServer binds a minimal HTTP server exposing /debug/vars.
+// addr is expected to be a loopback address (e.g. "127.0.0.1:9090") —
+// this endpoint is unauthenticated and meant for local scraping only.
+func StartMetricsServer(addr string, router *Router) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", router.ServeDebugVars)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen: %w", err)
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+
+	log.Printf("Metrics server listening on %s", addr)
+	return srv, nil
+}
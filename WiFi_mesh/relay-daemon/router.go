@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"sync"
 )
@@ -12,23 +13,34 @@ type Router struct {
 	mu            sync.RWMutex
 	clients       map[*Client]bool
 	mesh          *MeshLink
-	buffer        *PacketBuffer
+	buffer        *PersistentBuffer
 	dedup         *DedupFilter
 	GlobalLimiter *TokenBucket
+	Admission     *AdmissionController
+	metrics       RouterMetrics
 }
 
-func NewRouter(cfg *Config) *Router {
+func NewRouter(cfg *Config) (*Router, error) {
+	buffer, err := NewPersistentBuffer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("store-and-forward buffer: %w", err)
+	}
 	return &Router{
 		clients:       make(map[*Client]bool),
-		buffer:        NewPacketBuffer(cfg.BufferSize),
+		buffer:        buffer,
 		dedup:         NewDedupFilter(cfg.DedupMaxEntries),
 		GlobalLimiter: NewTokenBucket(cfg.GlobalPacketsPerSec, cfg.GlobalBurstSize),
-	}
+		Admission:     NewAdmissionController(cfg),
+	}, nil
 }
 
 func (r *Router) SetMesh(m *MeshLink) { r.mesh = m }
 
-func (r *Router) AddClient(c *Client) {
+// AddClient registers a newly (re)connected client and replays any
+// buffered packets newer than the phone's advertised resumeCursor —
+// 0 for a phone that has no prior session, which replays everything
+// still within the buffer's TTL.
+func (r *Router) AddClient(c *Client, resumeCursor uint64) {
 	r.mu.Lock()
 	r.clients[c] = true
 	count := len(r.clients)
@@ -36,11 +48,17 @@ func (r *Router) AddClient(c *Client) {
 
 	log.Printf("Client connected: %s (peer %s) [%d total]", c.addr, c.peerID, count)
 
-	for _, pkt := range r.buffer.GetAll() {
+	for _, pkt := range r.buffer.GetSince(resumeCursor) {
 		c.SendData(pkt)
 	}
 }
 
+// Close releases resources held by the router, including the
+// store-and-forward buffer's on-disk database, if any.
+func (r *Router) Close() error {
+	return r.buffer.Close()
+}
+
 func (r *Router) RemoveClient(c *Client) {
 	r.mu.Lock()
 	delete(r.clients, c)
@@ -12,39 +12,71 @@ import (
 	"log"
 	"math/big"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type Server struct {
 	cfg      *Config
 	router   *Router
 	listener net.Listener
+	acmeMgr  *autocert.Manager // non-nil when cfg.ACMEDomain is set
 }
 
 func NewServer(cfg *Config, router *Router) (*Server, error) {
-	cert, err := loadOrGenerateCert(cfg.CertDir)
-	if err != nil {
-		return nil, fmt.Errorf("TLS cert: %w", err)
-	}
+	s := &Server{cfg: cfg, router: router}
 
-	tlsCfg := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS13,
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS13}
+
+	if cfg.ACMEDomain != "" {
+		s.acmeMgr = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomain),
+			Cache:      autocert.DirCache(cfg.ACMECache),
+			Email:      cfg.ACMEEmail,
+		}
+		tlsCfg.GetCertificate = s.acmeMgr.GetCertificate
+		tlsCfg.NextProtos = append(tlsCfg.NextProtos, acme.ALPNProto)
+	} else {
+		// Ensure a cert/key pair exists (generating a self-signed one on
+		// first run), then hand ongoing serving over to a CertReloader so
+		// an operator-rotated cert is picked up without a restart.
+		if _, err := loadOrGenerateCert(cfg.CertDir); err != nil {
+			return nil, fmt.Errorf("TLS cert: %w", err)
+		}
+		reloader, err := NewCertReloader(
+			filepath.Join(cfg.CertDir, "relay.crt"),
+			filepath.Join(cfg.CertDir, "relay.key"),
+			cfg.TLSCertReloadInterval,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("TLS cert: %w", err)
+		}
+		tlsCfg.GetCertificate = reloader.GetCertificate
 	}
 
 	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", cfg.TLSPort), tlsCfg)
 	if err != nil {
 		return nil, fmt.Errorf("listen: %w", err)
 	}
+	s.listener = listener
 
-	return &Server{cfg: cfg, router: router, listener: listener}, nil
+	return s, nil
 }
 
 func (s *Server) Serve() {
 	log.Printf("TLS server listening on :%d", s.cfg.TLSPort)
 
+	if s.acmeMgr != nil {
+		go s.serveACMEHTTPChallenge()
+	}
+
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
@@ -65,16 +97,27 @@ func (s *Server) Serve() {
 	}
 }
 
+// serveACMEHTTPChallenge answers HTTP-01 challenges on :80. It's a
+// fallback path — TLS-ALPN-01 (acme.ALPNProto in NextProtos above)
+// handles renewal without needing port 80 open, but HTTP-01 is kept
+// available since not every deployment can expose ALPN on the TLS
+// port to the ACME CA.
+func (s *Server) serveACMEHTTPChallenge() {
+	if err := http.ListenAndServe(":80", s.acmeMgr.HTTPHandler(nil)); err != nil {
+		log.Printf("WARNING: ACME HTTP-01 challenge server unavailable: %v", err)
+	}
+}
+
 func (s *Server) handleConn(conn *tls.Conn) {
-	peerID, err := PerformHandshake(conn, s.cfg)
+	peerID, resumeCursor, err := PerformHandshake(conn, s.cfg, s.router.Admission, s.router.ClientCount())
 	if err != nil {
 		log.Printf("handshake failed (%s): %v", conn.RemoteAddr(), err)
 		conn.Close()
 		return
 	}
 
-	client := NewClient(conn, peerID, s.cfg)
-	s.router.AddClient(client)
+	client := NewClient(conn, peerID, s.cfg, s.router)
+	s.router.AddClient(client, resumeCursor)
 
 	go client.WriteLoop()
 	client.ReadLoop(s.router, s.cfg) // blocks until disconnect
@@ -136,3 +179,62 @@ func loadOrGenerateCert(certDir string) (tls.Certificate, error) {
 	log.Printf("Generated self-signed TLS certificate: %s", certFile)
 	return tls.LoadX509KeyPair(certFile, keyFile)
 }
+
+// CertReloader periodically re-reads a TLS certificate/key pair from
+// disk and serves tls.Config.GetCertificate from whichever copy was
+// most recently loaded, so a rotated cert takes effect on its next
+// tick without an operator-visible reconnect.
+type CertReloader struct {
+	certPath       string
+	keyPath        string
+	reloadInterval time.Duration
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func NewCertReloader(certPath, keyPath string, reloadInterval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{certPath: certPath, keyPath: keyPath, reloadInterval: reloadInterval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		go r.reloadLoop()
+	}
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load TLS keypair: %w", err)
+	}
+
+	expiry := "unknown"
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		expiry = leaf.NotAfter.UTC().Format(time.RFC3339)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	log.Printf("TLS certificate loaded from %s (expires %s)", r.certPath, expiry)
+	return nil
+}
+
+func (r *CertReloader) reloadLoop() {
+	ticker := time.NewTicker(r.reloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.reload(); err != nil {
+			log.Printf("WARNING: TLS cert reload failed, keeping previous cert: %v", err)
+		}
+	}
+}
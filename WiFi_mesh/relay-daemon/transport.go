@@ -0,0 +1,48 @@
+package main
+
+import "fmt"
+
+// PeerInfo identifies the far end of a message delivered by a
+// MeshTransport, in whatever terms that transport speaks natively (a
+// UDP address, a QUIC remote address, a libp2p peer ID). MeshLink uses
+// it only for logging — peer identity for trust and session purposes
+// comes from the Ed25519 signature carried inside the message itself,
+// not from the transport.
+type PeerInfo struct {
+	Addr string
+}
+
+// MeshTransport delivers opaque framed messages between relay
+// daemons. It has no notion of message semantics (signed envelope vs.
+// Noise-encrypted DATA) or destination: Send fans a message out to
+// every other relay reachable through the transport, mirroring the
+// broadcast nature of the original batman-adv multicast link so that
+// MeshLink's signing, dedup, and session logic above it is identical
+// regardless of which backend is selected.
+type MeshTransport interface {
+	// Send broadcasts data to every relay reachable through this
+	// transport.
+	Send(data []byte) error
+	// Recv blocks until a message arrives, returning its payload and
+	// the sender's transport-level identity. It is called from a
+	// single goroutine; implementations need not be safe for
+	// concurrent Recv calls.
+	Recv() ([]byte, PeerInfo, error)
+	Close() error
+}
+
+// NewMeshTransport builds the MeshTransport selected by
+// cfg.MeshTransport: "multicast" (the default, original batman-adv
+// UDP link), "quic", or "libp2p".
+func NewMeshTransport(cfg *Config) (MeshTransport, error) {
+	switch cfg.MeshTransport {
+	case "", "multicast":
+		return newMulticastTransport(cfg)
+	case "quic":
+		return newQUICTransport(cfg)
+	case "libp2p":
+		return newLibp2pTransport(cfg)
+	default:
+		return nil, fmt.Errorf("unknown mesh transport %q", cfg.MeshTransport)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	multiaddr "github.com/multiformats/go-multiaddr"
+)
+
+const defaultLibp2pTopic = "bitchat-mesh"
+
+// libp2pTransport carries mesh envelopes over go-libp2p pubsub using
+// the gossipsub router. Gossipsub gives efficient multi-relay fanout
+// on the WAN (each relay gossips with a handful of mesh peers rather
+// than every relay needing a direct link to every other), which is
+// what a growing relay fleet needs once it outgrows a single
+// batman-adv broadcast domain.
+type libp2pTransport struct {
+	host  host.Host
+	ps    *pubsub.PubSub
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newLibp2pTransport(cfg *Config) (MeshTransport, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings(cfg.MeshLibp2pListen))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("libp2p host: %w", err)
+	}
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		cancel()
+		h.Close()
+		return nil, fmt.Errorf("gossipsub: %w", err)
+	}
+
+	topicName := cfg.MeshLibp2pTopic
+	if topicName == "" {
+		topicName = defaultLibp2pTopic
+	}
+	topic, err := ps.Join(topicName)
+	if err != nil {
+		cancel()
+		h.Close()
+		return nil, fmt.Errorf("join topic %s: %w", topicName, err)
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		cancel()
+		topic.Close()
+		h.Close()
+		return nil, fmt.Errorf("subscribe %s: %w", topicName, err)
+	}
+
+	t := &libp2pTransport{host: h, ps: ps, topic: topic, sub: sub, ctx: ctx, cancel: cancel}
+
+	for _, seed := range cfg.MeshLibp2pPeers {
+		go t.connectSeed(seed)
+	}
+
+	log.Printf("libp2p mesh transport listening (peer %s), topic %q", h.ID(), topicName)
+	return t, nil
+}
+
+// connectSeed dials a statically configured peer multiaddr (e.g.
+// "/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmPeerID") so the gossipsub mesh
+// has at least one known neighbor to build outward from. No peer
+// discovery is configured, so the host connects only to these seeds
+// and to peers that dial it.
+func (t *libp2pTransport) connectSeed(addr string) {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		log.Printf("libp2p transport: bad seed multiaddr %q: %v", addr, err)
+		return
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		log.Printf("libp2p transport: bad seed peer info %q: %v", addr, err)
+		return
+	}
+	if err := t.host.Connect(t.ctx, *info); err != nil {
+		log.Printf("libp2p transport: connect seed %s: %v", info.ID, err)
+	}
+}
+
+func (t *libp2pTransport) Send(data []byte) error {
+	return t.topic.Publish(t.ctx, data)
+}
+
+func (t *libp2pTransport) Recv() ([]byte, PeerInfo, error) {
+	for {
+		msg, err := t.sub.Next(t.ctx)
+		if err != nil {
+			return nil, PeerInfo{}, err
+		}
+		// Gossipsub delivers our own publications back to us; the
+		// signed-envelope layer above would otherwise treat them as a
+		// peer loop.
+		if msg.ReceivedFrom == t.host.ID() {
+			continue
+		}
+		return msg.Data, PeerInfo{Addr: msg.GetFrom().String()}, nil
+	}
+}
+
+func (t *libp2pTransport) Close() error {
+	t.sub.Cancel()
+	t.topic.Close()
+	t.cancel()
+	return t.host.Close()
+}
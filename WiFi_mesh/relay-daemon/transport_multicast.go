@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// multicastTransport is the original mesh backend: IPv4 UDP multicast
+// on a batman-adv (or any L2-multicast-capable) interface. It remains
+// the default — no seed list or external signaling required — at the
+// cost of needing a shared broadcast domain, which rules out NAT'd or
+// WAN deployments.
+type multicastTransport struct {
+	sendConn *net.UDPConn
+	recvConn *net.UDPConn
+	buf      []byte
+}
+
+func newMulticastTransport(cfg *Config) (MeshTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%d", cfg.MeshMulticast, cfg.MeshPort))
+	if err != nil {
+		return nil, fmt.Errorf("resolve multicast: %w", err)
+	}
+
+	iface, err := net.InterfaceByName(cfg.MeshInterface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", cfg.MeshInterface, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses on %s: %w", cfg.MeshInterface, err)
+	}
+	var localIP net.IP
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			localIP = ipnet.IP
+			break
+		}
+	}
+	if localIP == nil {
+		return nil, fmt.Errorf("no IPv4 address on %s", cfg.MeshInterface)
+	}
+
+	sendConn, err := net.DialUDP("udp4", &net.UDPAddr{IP: localIP}, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial multicast: %w", err)
+	}
+
+	recvConn, err := net.ListenMulticastUDP("udp4", iface, addr)
+	if err != nil {
+		sendConn.Close()
+		return nil, fmt.Errorf("listen multicast: %w", err)
+	}
+	recvConn.SetReadBuffer(1 << 20)
+
+	return &multicastTransport{
+		sendConn: sendConn,
+		recvConn: recvConn,
+		buf:      make([]byte, cfg.MaxPacketSize+caHeaderLen+2),
+	}, nil
+}
+
+func (t *multicastTransport) Send(data []byte) error {
+	_, err := t.sendConn.Write(data)
+	return err
+}
+
+func (t *multicastTransport) Recv() ([]byte, PeerInfo, error) {
+	n, addr, err := t.recvConn.ReadFromUDP(t.buf)
+	if err != nil {
+		return nil, PeerInfo{}, err
+	}
+	return append([]byte(nil), t.buf[:n]...), PeerInfo{Addr: addr.String()}, nil
+}
+
+func (t *multicastTransport) Close() error {
+	t.sendConn.Close()
+	return t.recvConn.Close()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const quicALPN = "bitchat-mesh"
+const quicRedialInterval = 5 * time.Second
+
+// quicTransport carries mesh envelopes over QUIC datagrams (RFC 9221)
+// instead of L2 multicast, so relays can reach each other across NAT'd
+// links or the public internet given a configured seed address list.
+// Authentication and integrity are provided by MeshLink above it (the
+// signed envelope for presence/handshake messages, the Noise session's
+// AEAD for DATA), so the QUIC layer here is
+// opportunistic transport encryption only: both sides present
+// ephemeral self-signed certificates and skip verification.
+type quicTransport struct {
+	listener *quic.Listener
+
+	mu    sync.Mutex
+	conns map[string]quic.Connection // remote addr -> live connection
+
+	recvCh chan quicMsg
+	closed chan struct{}
+}
+
+type quicMsg struct {
+	data []byte
+	peer PeerInfo
+}
+
+func newQUICTransport(cfg *Config) (MeshTransport, error) {
+	tlsConf, err := ephemeralQUICTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("quic tls: %w", err)
+	}
+
+	ln, err := quic.ListenAddr(cfg.MeshQUICListen, tlsConf, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		return nil, fmt.Errorf("quic listen %s: %w", cfg.MeshQUICListen, err)
+	}
+
+	t := &quicTransport{
+		listener: ln,
+		conns:    make(map[string]quic.Connection),
+		recvCh:   make(chan quicMsg, 64),
+		closed:   make(chan struct{}),
+	}
+
+	go t.acceptLoop()
+	for _, peer := range cfg.MeshQUICPeers {
+		go t.dialSeed(peer, tlsConf)
+	}
+
+	log.Printf("QUIC mesh transport listening on %s (%d seed peers)", cfg.MeshQUICListen, len(cfg.MeshQUICPeers))
+	return t, nil
+}
+
+func (t *quicTransport) acceptLoop() {
+	for {
+		conn, err := t.listener.Accept(context.Background())
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			default:
+			}
+			log.Printf("quic transport: accept: %v", err)
+			continue
+		}
+		t.addConn(conn)
+	}
+}
+
+// dialSeed keeps a seed address connected, redialing with a fixed
+// backoff whenever the connection drops, so a configured peer list
+// gives the same always-reachable feel as a shared multicast domain.
+func (t *quicTransport) dialSeed(addr string, tlsConf *tls.Config) {
+	for {
+		select {
+		case <-t.closed:
+			return
+		default:
+		}
+
+		conn, err := quic.DialAddr(context.Background(), addr, tlsConf, &quic.Config{EnableDatagrams: true})
+		if err != nil {
+			log.Printf("quic transport: dial %s: %v", addr, err)
+			time.Sleep(quicRedialInterval)
+			continue
+		}
+		t.addConn(conn)
+		<-conn.Context().Done()
+		time.Sleep(quicRedialInterval)
+	}
+}
+
+func (t *quicTransport) addConn(conn quic.Connection) {
+	key := conn.RemoteAddr().String()
+	t.mu.Lock()
+	t.conns[key] = conn
+	t.mu.Unlock()
+	go t.recvLoop(conn, key)
+}
+
+func (t *quicTransport) recvLoop(conn quic.Connection, key string) {
+	defer func() {
+		t.mu.Lock()
+		if t.conns[key] == conn {
+			delete(t.conns, key)
+		}
+		t.mu.Unlock()
+	}()
+
+	for {
+		data, err := conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		msg := quicMsg{data: data, peer: PeerInfo{Addr: key}}
+		select {
+		case t.recvCh <- msg:
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// Send fans data out to every live QUIC connection (dialed seeds and
+// accepted inbound peers), mirroring multicast's broadcast semantics.
+func (t *quicTransport) Send(data []byte) error {
+	t.mu.Lock()
+	conns := make([]quic.Connection, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	var firstErr error
+	for _, c := range conns {
+		if err := c.SendDatagram(data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *quicTransport) Recv() ([]byte, PeerInfo, error) {
+	select {
+	case msg := <-t.recvCh:
+		return msg.data, msg.peer, nil
+	case <-t.closed:
+		return nil, PeerInfo{}, fmt.Errorf("quic transport closed")
+	}
+}
+
+func (t *quicTransport) Close() error {
+	select {
+	case <-t.closed:
+	default:
+		close(t.closed)
+	}
+	t.mu.Lock()
+	for _, c := range t.conns {
+		c.CloseWithError(0, "closing")
+	}
+	t.mu.Unlock()
+	return t.listener.Close()
+}
+
+// ephemeralQUICTLSConfig generates a throwaway self-signed certificate
+// for the QUIC handshake. It is not persisted and not tied to the
+// relay's Ed25519 identity — QUIC here is a transport, not a trust
+// anchor.
+func ephemeralQUICTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicALPN},
+	}, nil
+}